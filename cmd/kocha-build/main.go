@@ -107,6 +107,7 @@ func (c *buildCommand) Run(args []string) error {
 		"mainFilePath":        mainFilePath,
 		"resources":           resources,
 		"version":             tag,
+		"buildTime":           time.Now().Unix(),
 	}
 	if err := t.Execute(file, data); err != nil {
 		return fmt.Errorf("failed to write file: %v", err)
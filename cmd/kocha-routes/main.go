@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"text/template"
+
+	"github.com/woremacx/kocha/util"
+)
+
+type routesCommand struct {
+	option struct {
+		JSON  bool `short:"j" long:"json"`
+		Check bool `short:"c" long:"check"`
+		Help  bool `short:"h" long:"help"`
+	}
+}
+
+func (c *routesCommand) Name() string {
+	return "kocha routes"
+}
+
+func (c *routesCommand) Usage() string {
+	return fmt.Sprintf(`Usage: %s [OPTIONS]
+
+List the routing table of your application.
+
+Options:
+    -j, --json        output in JSON format
+    -c, --check       report the routes whose names clash or whose paths
+                      shadow each other, and exit with non-zero status if any
+    -h, --help        display this help and exit
+
+`, c.Name())
+}
+
+func (c *routesCommand) Option() interface{} {
+	return &c.option
+}
+
+func (c *routesCommand) Run(args []string) error {
+	appDir, err := util.FindAppDir()
+	if err != nil {
+		return err
+	}
+	configPkg, err := getPackage(path.Join(appDir, "config"))
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "kocha-routes")
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	_, filename, _, _ := runtime.Caller(0)
+	skeletonDir := filepath.Join(filepath.Dir(filename), "skeleton", "routes")
+	t := template.Must(template.ParseFiles(filepath.Join(skeletonDir, "routes.go"+util.TemplateSuffix)))
+	mainFilePath := filepath.ToSlash(filepath.Join(tmpDir, "routes.go"))
+	file, err := os.Create(mainFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer file.Close()
+	data := map[string]interface{}{
+		"configImportPath": configPkg.ImportPath,
+		"json":             c.option.JSON,
+		"check":            c.option.Check,
+	}
+	if err := t.Execute(file, data); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	file.Close()
+	return execCmd("go", "run", mainFilePath)
+}
+
+func getPackage(importPath string) (*build.Package, error) {
+	pkg, err := build.Import(importPath, "", build.FindOnly)
+	if err != nil {
+		return nil, fmt.Errorf(`cannot import "%s": %v`, importPath, err)
+	}
+	return pkg, nil
+}
+
+func execCmd(cmd string, args ...string) error {
+	command := exec.Command(cmd, args...)
+	command.Stdout, command.Stderr = os.Stdout, os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("routes failed: %v", err)
+	}
+	return nil
+}
+
+func main() {
+	util.RunCommand(&routesCommand{})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_routesCommand_Name(t *testing.T) {
+	c := &routesCommand{}
+	var actual interface{} = c.Name()
+	var expect interface{} = "kocha routes"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`%T.Name() => %#v; want %#v`, c, actual, expect)
+	}
+}
+
+func Test_routesCommand_Run_withNoENVGiven(t *testing.T) {
+	c := &routesCommand{}
+	args := []string{}
+	err := c.Run(args)
+	actual := err.Error()
+	expect := "cannot import "
+	if !strings.HasPrefix(actual, expect) {
+		t.Errorf(`%T.Run(%#v) => %#v; want %#v`, c, args, actual, expect)
+	}
+}
+
+// runRoutesCommand runs the command in the test application with the extra
+// files and returns the error and the outputs of stdout and stderr.
+func runRoutesCommand(t *testing.T, c *routesCommand, files map[string]string) (err error, stdout, stderr string) {
+	tempDir, err := ioutil.TempDir("", "Test_routesCommand_Run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	dstPath := filepath.Join(tempDir, "src", "testappname")
+	_, filename, _, _ := runtime.Caller(0)
+	testdataDir := filepath.Join(filepath.Dir(filename), "testdata")
+	if err := copyAll(testdataDir, dstPath); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dstPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dstPath); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(origDir)
+	origGOPATH := build.Default.GOPATH
+	defer func() {
+		build.Default.GOPATH = origGOPATH
+		os.Setenv("GOPATH", origGOPATH)
+	}()
+	build.Default.GOPATH = tempDir + string(filepath.ListSeparator) + build.Default.GOPATH
+	os.Setenv("GOPATH", build.Default.GOPATH)
+	outFile, err := ioutil.TempFile(tempDir, "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	errFile, err := ioutil.TempFile(tempDir, "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer errFile.Close()
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+	err = c.Run([]string{})
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+	out, e := ioutil.ReadFile(outFile.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+	errOut, e := ioutil.ReadFile(errFile.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+	return err, string(out), string(errOut)
+}
+
+func Test_routesCommand_Run(t *testing.T) {
+	c := &routesCommand{}
+	c.option.JSON = true
+	err, stdout, stderr := runRoutesCommand(t, c, nil)
+	if err != nil {
+		t.Fatalf(`%T.Run([]string{}) => %#v; want %#v; stderr => %#v`, c, err, nil, stderr)
+	}
+	var actual []map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &actual); err != nil {
+		t.Fatal(err)
+	}
+	methods := []interface{}{"GET"}
+	expect := []map[string]interface{}{
+		{"name": "root", "path": "/", "params": []interface{}{}, "controller": "*controller.Root", "methods": methods},
+		{"name": "item", "path": "/items/:id", "params": []interface{}{":id"}, "controller": "*controller.Item", "methods": methods},
+		{"name": "new_item", "path": "/items/new", "params": []interface{}{}, "controller": "*controller.Item", "methods": methods},
+		{"name": "static", "path": "/*path", "params": []interface{}{"*path"}, "controller": "*kocha.StaticServe", "methods": methods},
+	}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`%T.Run([]string{}); output => %#v; want %#v`, c, actual, expect)
+	}
+}
+
+func Test_routesCommand_Run_withCheck(t *testing.T) {
+	for _, v := range []struct {
+		files    map[string]string
+		err      interface{}
+		warnings []string
+	}{
+		{nil, nil, nil},
+		{map[string]string{
+			"config/routes_unreachable.go": `package config
+
+import (
+	"testappname/app/controller"
+
+	"github.com/woremacx/kocha"
+)
+
+func init() {
+	AppConfig.RouteTable = append(AppConfig.RouteTable, &kocha.Route{
+		Name:       "item_by_slug",
+		Path:       "/items/:slug<[a-z-]+>",
+		Controller: &controller.Item{},
+	})
+}
+`,
+		}, "routes failed: exit status 1", []string{"WARNING: item_by_slug: path is shadowed by the route `item'"}},
+	} {
+		c := &routesCommand{}
+		c.option.Check = true
+		err, stdout, stderr := runRoutesCommand(t, c, v.files)
+		var actual interface{} = nil
+		if err != nil {
+			actual = err.Error()
+		}
+		var expect interface{} = v.err
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%T.Run([]string{}) with %#v => %#v; want %#v; stderr => %#v`, c, v.files, actual, expect, stderr)
+		}
+		actual = strings.Contains(stdout, "/items/new")
+		expect = true
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%T.Run([]string{}) with %#v; stdout => %#v; want the route table`, c, v.files, stdout)
+		}
+		var warnings []string
+		for _, line := range strings.Split(stderr, "\n") {
+			if strings.HasPrefix(line, "WARNING:") {
+				warnings = append(warnings, line)
+			}
+		}
+		actual = warnings
+		expect = v.warnings
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%T.Run([]string{}) with %#v; warnings => %#v; want %#v`, c, v.files, actual, expect)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package controller
+
+import (
+	"github.com/woremacx/kocha"
+)
+
+type Item struct {
+	*kocha.DefaultController
+}
+
+func (it *Item) GET(c *kocha.Context) error {
+	return c.Render(nil)
+}
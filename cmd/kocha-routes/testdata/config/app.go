@@ -0,0 +1,35 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+
+	"github.com/woremacx/kocha"
+)
+
+var (
+	AppName   = "testappname"
+	AppConfig = &kocha.Config{
+		AppPath:       rootPath,
+		AppName:       AppName,
+		DefaultLayout: "app",
+		Template: &kocha.Template{
+			PathInfo: kocha.TemplatePathInfo{
+				Name: AppName,
+				Paths: []string{
+					filepath.Join(rootPath, "app", "view"),
+				},
+			},
+		},
+		Logger: &kocha.LoggerConfig{
+			Writer: ioutil.Discard,
+		},
+		Middlewares: []kocha.Middleware{
+			&kocha.DispatchMiddleware{},
+		},
+	}
+
+	_, configFileName, _, _ = runtime.Caller(0)
+	rootPath                = filepath.Dir(filepath.Join(configFileName, ".."))
+)
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testappname/app/controller"
+
+	"github.com/woremacx/kocha"
+)
+
+func init() {
+	AppConfig.RouteTable = kocha.RouteTable{
+		{
+			Name:       "root",
+			Path:       "/",
+			Controller: &controller.Root{},
+		},
+		{
+			Name:       "item",
+			Path:       "/items/:id",
+			Controller: &controller.Item{},
+		},
+		{
+			Name:       "new_item",
+			Path:       "/items/new",
+			Controller: &controller.Item{},
+		},
+		{
+			Name:       "static",
+			Path:       "/*path",
+			Controller: &kocha.StaticServe{},
+		},
+	}
+}
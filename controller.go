@@ -2,7 +2,7 @@ package kocha
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -15,6 +15,9 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/woremacx/kocha/log"
 )
 
 var contextPool = &sync.Pool{
@@ -63,11 +66,22 @@ type Patcher interface {
 	PATCH(c *Context) error
 }
 
+// Optioner interface is an interface representing a handler for HTTP OPTIONS request.
+// If the controller doesn't implement Optioner, the router responds to an
+// OPTIONS request automatically with the Allow header.
+type Optioner interface {
+	OPTIONS(c *Context) error
+}
+
 type requestHandler func(c *Context) error
 
 // DefaultController implements Controller interface.
 // This can be used to save the trouble to implement all of the methods of
 // Controller interface.
+//
+// The methods that are promoted from the embedded DefaultController are
+// regarded as not implemented by the router. A request to such method will be
+// responded by the HTTP 405 Method Not Allowed with the Allow header.
 type DefaultController struct {
 }
 
@@ -105,10 +119,12 @@ type mimeTypeFormats map[string]string
 
 // MimeTypeFormats is relation between mime type and file extension.
 var MimeTypeFormats = mimeTypeFormats{
-	"application/json": "json",
-	"application/xml":  "xml",
-	"text/html":        "html",
-	"text/plain":       "txt",
+	"application/json":      "json",
+	"application/x-msgpack": "msgpack",
+	"application/xml":       "xml",
+	"text/csv":              "csv",
+	"text/html":             "html",
+	"text/plain":            "txt",
 }
 
 // Get returns the file extension from the mime type.
@@ -138,11 +154,23 @@ type Context struct {
 	Session  Session      // session.
 	Flash    Flash        // flash messages.
 	App      *Application // an application.
+	Logger   log.Logger   // request-scoped logger.
 
 	// Errors represents the map of errors that related to the form values.
 	// A map key is field name, and value is slice of errors.
 	// Errors will be set by Context.Params.Bind().
 	Errors map[string][]*ParamError
+
+	route         *Route      // dispatched route.
+	match         *routeMatch // result of the routing. See Application.lookup.
+	rewrites      int         // number of the internal rewrites.
+	csrfToken     string      // token of CSRFMiddleware.
+	csrfFieldName string      // form field name of the token of CSRFMiddleware.
+	cspNonce      string      // nonce of the Content-Security-Policy.
+	negotiated    string      // content type that has been negotiated.
+
+	ctx    context.Context    // context of the request without the deadline.
+	cancel context.CancelFunc // cancels the deadline of the request.
 }
 
 func newContext() *Context {
@@ -177,6 +205,8 @@ func errorWithLine(err error, calldepth int) error {
 // e.g. If controller name is "root" and ContentType is "application/xml", Render will
 // try to retrieve the template file "root.xml".
 // Also ContentType set to "text/html" if not specified.
+// If the format has been negotiated by ContentNegotiationMiddleware and the
+// template file doesn't exist, it renders the HTTP 406 Not Acceptable.
 func (c *Context) Render(data interface{}) error {
 	if err := c.setData(data); err != nil {
 		return c.errorWithLine(err)
@@ -185,6 +215,12 @@ func (c *Context) Render(data interface{}) error {
 	if err := c.setFormatFromContentTypeIfNotExists(); err != nil {
 		return c.errorWithLine(err)
 	}
+	if c.negotiated != "" && !c.templateExists() {
+		// renders the error page in the default format since the format
+		// that the client accepts isn't available.
+		c.Format, c.Response.ContentType = "", ""
+		return c.RenderError(http.StatusNotAcceptable, nil, nil)
+	}
 	t, err := c.App.Template.Get(c.App.Config.AppName, c.Layout, c.Name, c.Format)
 	if err != nil {
 		return c.errorWithLine(err)
@@ -207,15 +243,54 @@ func (c *Context) Render(data interface{}) error {
 //
 // RenderJSON is similar to Render but data will be encoded to JSON.
 // ContentType set to "application/json" if not specified.
+// If the JSONP callback is given by the parameter of
+// Config.JSON.JSONPCallbackParam, it renders the JSONP as
+// "application/javascript".
+// The encoding can be configured by Config.JSON. See JSONConfig.
 func (c *Context) RenderJSON(data interface{}) error {
 	if err := c.setData(data); err != nil {
 		return c.errorWithLine(err)
 	}
-	c.setContentTypeIfNotExists("application/json")
-	buf, err := json.Marshal(c.Data)
+	config := defaultJSONConfig
+	if c.App != nil && c.App.Config.JSON != nil {
+		config = c.App.Config.JSON
+	}
+	var callback string
+	if config.JSONPCallbackParam != "" {
+		if callback = c.Request.URL.Query().Get(config.JSONPCallbackParam); callback != "" {
+			if !isValidJSONPCallback(callback) {
+				return c.RenderError(http.StatusBadRequest, nil, nil)
+			}
+			c.Response.ContentType = "application/javascript"
+		}
+	}
+	c.setRenderedContentType("application/json")
+	opts := MarshalOptions{Indent: config.Indent, EscapeHTML: !config.DisableHTMLEscape}
+	var prefix, suffix string
+	if callback != "" {
+		// the comment prevents the callback from being interpreted as the
+		// other content such as Flash.
+		prefix, suffix = "/**/"+callback+"(", ");"
+	}
+	if config.Stream {
+		return c.Stream(func(w io.Writer) error {
+			if _, err := io.WriteString(w, prefix); err != nil {
+				return err
+			}
+			if err := c.encodeElements(w, config.Marshaler.NewEncoder(w, opts), "[", ",", "]"); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, suffix)
+			return err
+		})
+	}
+	buf, err := config.Marshaler.Marshal(c.Data, opts)
 	if err != nil {
 		return c.errorWithLine(err)
 	}
+	if callback != "" {
+		buf = append(append([]byte(prefix), buf...), suffix...)
+	}
 	if err := c.render(bytes.NewReader(buf)); err != nil {
 		return c.errorWithLine(err)
 	}
@@ -226,26 +301,97 @@ func (c *Context) RenderJSON(data interface{}) error {
 //
 // RenderXML is similar to Render but data will be encoded to XML.
 // ContentType set to "application/xml" if not specified.
+// The encoding can be configured by Config.XML. See XMLConfig.
 func (c *Context) RenderXML(data interface{}) error {
 	if err := c.setData(data); err != nil {
 		return c.errorWithLine(err)
 	}
-	c.setContentTypeIfNotExists("application/xml")
-	buf, err := xml.Marshal(c.Data)
+	config := defaultXMLConfig
+	if c.App != nil && c.App.Config.XML != nil {
+		config = c.App.Config.XML
+	}
+	c.setRenderedContentType("application/xml")
+	var header string
+	if config.Header {
+		header = xml.Header
+	}
+	opts := MarshalOptions{Indent: config.Indent}
+	if config.Stream {
+		var open, close string
+		if isElements(c.Data) {
+			if config.StreamRoot == "" {
+				return c.errorWithLine(fmt.Errorf("kocha: xml: XMLConfig.StreamRoot must be set to stream a slice"))
+			}
+			open, close = "<"+config.StreamRoot+">", "</"+config.StreamRoot+">"
+		}
+		return c.Stream(func(w io.Writer) error {
+			if _, err := io.WriteString(w, header); err != nil {
+				return err
+			}
+			return c.encodeElements(w, config.Marshaler.NewEncoder(w, opts), open, "", close)
+		})
+	}
+	buf, err := config.Marshaler.Marshal(c.Data, opts)
 	if err != nil {
 		return c.errorWithLine(err)
 	}
+	if header != "" {
+		buf = append([]byte(header), buf...)
+	}
 	if err := c.render(bytes.NewReader(buf)); err != nil {
 		return c.errorWithLine(err)
 	}
 	return nil
 }
 
+// RenderMsgpack renders the data as MessagePack.
+//
+// RenderMsgpack is similar to RenderJSON but data will be encoded to
+// MessagePack.
+// ContentType set to "application/x-msgpack" if not specified.
+func (c *Context) RenderMsgpack(data interface{}) error {
+	return c.RenderAs("application/x-msgpack", data)
+}
+
+// RenderCSV renders the data as CSV.
+//
+// data must be [][]string or a slice of the structs. See CSVRenderer.
+// ContentType set to "text/csv" if not specified.
+func (c *Context) RenderCSV(data interface{}) error {
+	return c.RenderAs("text/csv", data)
+}
+
+// RenderAs renders the data by the Renderer that is registered for
+// contentType by RegisterRenderer.
+// ContentType set to contentType if not specified.
+func (c *Context) RenderAs(contentType string, data interface{}) error {
+	r := lookupRenderer(contentType)
+	if r == nil {
+		return c.errorWithLine(fmt.Errorf("kocha: renderer isn't registered for %v", contentType))
+	}
+	if err := c.setData(data); err != nil {
+		return c.errorWithLine(err)
+	}
+	c.setRenderedContentType(contentType)
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufPool.Put(buf)
+	}()
+	if err := r.Render(buf, c.Data); err != nil {
+		return c.errorWithLine(err)
+	}
+	if err := c.render(buf); err != nil {
+		return c.errorWithLine(err)
+	}
+	return nil
+}
+
 // RenderText renders the content.
 //
 // ContentType set to "text/plain" if not specified.
 func (c *Context) RenderText(content string) error {
-	c.setContentTypeIfNotExists("text/plain")
+	c.setRenderedContentType("text/plain")
 	if err := c.render(strings.NewReader(content)); err != nil {
 		return c.errorWithLine(err)
 	}
@@ -255,7 +401,7 @@ func (c *Context) RenderText(content string) error {
 // RenderError renders an error page with statusCode.
 //
 // RenderError is similar to Render, but there is the points where some different.
-// If err is not nil, RenderError outputs the err to log using c.App.Logger.Error.
+// If err is not nil, RenderError outputs the err to log using c.Logger.Error.
 // RenderError retrieves a template file from statusCode and c.Response.ContentType.
 // e.g. If statusCode is 500 and ContentType is "application/xml", RenderError will
 // try to retrieve the template file "errors/500.xml".
@@ -263,7 +409,7 @@ func (c *Context) RenderText(content string) error {
 // Also ContentType set to "text/html" if not specified.
 func (c *Context) RenderError(statusCode int, err error, data interface{}) error {
 	if err != nil {
-		c.App.Logger.Error(c.errorWithLine(err))
+		c.logger().Error(c.errorWithLine(err))
 	}
 	if err := c.setData(data); err != nil {
 		return c.errorWithLine(err)
@@ -304,8 +450,11 @@ func (c *Context) RenderError(statusCode int, err error, data interface{}) error
 // returns it if successful. Otherwise, Add AppPath and StaticDir to the prefix
 // of the path and then will read the content from the path that.
 // Also, set ContentType detect from content if c.Response.ContentType is empty.
+// The Last-Modified header is set from the modification time of the file, or
+// Config.BuildTime for the included resources.
 func (c *Context) SendFile(path string) error {
 	var file io.ReadSeeker
+	var modtime time.Time
 	path = filepath.FromSlash(path)
 	if rc := c.App.ResourceSet.Get(path); rc != nil {
 		switch b := rc.(type) {
@@ -314,17 +463,20 @@ func (c *Context) SendFile(path string) error {
 		case []byte:
 			file = bytes.NewReader(b)
 		}
+		modtime = c.App.Config.BuildTime
 	}
 	if file == nil {
 		if !filepath.IsAbs(path) {
 			path = filepath.Join(c.App.Config.AppPath, StaticDir, path)
 		}
-		if _, err := os.Stat(path); err != nil {
+		info, err := os.Stat(path)
+		if err != nil {
 			if err := c.RenderError(http.StatusNotFound, nil, nil); err != nil {
 				return c.errorWithLine(err)
 			}
 			return nil
 		}
+		modtime = info.ModTime()
 		f, err := os.Open(path)
 		if err != nil {
 			return c.errorWithLine(err)
@@ -340,26 +492,97 @@ func (c *Context) SendFile(path string) error {
 		}
 		c.Response.ContentType = ct
 	}
+	if !modtime.IsZero() {
+		c.Response.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
 	if err := c.render(file); err != nil {
 		return c.errorWithLine(err)
 	}
 	return nil
 }
 
+// Stream streams the response that is written by fn to the client.
+// The headers that have been set are sent at first, and the data that is
+// written to w is sent directly without the buffering. w implements
+// http.Flusher in order to send the data immediately.
+// Content-Type is "application/octet-stream" if it hasn't been set.
+//
+// fn should watch c.Context() in order to stop when the client has gone.
+// Any changes of the headers after Stream such as the session cookie
+// aren't sent, and the middlewares that need the whole of the response body
+// skip the streaming response. See Response.IsStreaming.
+func (c *Context) Stream(fn func(w io.Writer) error) error {
+	if c.Response.IsStreaming() {
+		return fmt.Errorf("kocha: stream: response has already been streamed")
+	}
+	c.setContentTypeIfNotExists("application/octet-stream")
+	c.Response.Header().Set("Content-Type", c.Response.ContentType)
+	w := c.Response.stream()
+	if err := fn(w); err != nil {
+		return c.errorWithLine(err)
+	}
+	w.Flush()
+	return nil
+}
+
+// SSE streams the events that are received from events to the client as the
+// Server-Sent Events. Each event is flushed immediately.
+// It returns when events is closed or c.Context() is done such as the client
+// has disconnected. Note that Config.Timeout also ends the stream, so
+// Route.Timeout of the route should be long enough.
+func (c *Context) SSE(events <-chan *SSEEvent) error {
+	c.Response.ContentType = "text/event-stream"
+	c.Response.Header().Set("Cache-Control", "no-cache")
+	c.Response.Header().Set("X-Accel-Buffering", "no")
+	done := c.Context().Done()
+	return c.Stream(func(w io.Writer) error {
+		for {
+			select {
+			case <-done:
+				return nil
+			case e, ok := <-events:
+				if !ok {
+					return nil
+				}
+				if err := e.writeTo(w); err != nil {
+					return err
+				}
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+}
+
 // Redirect renders result of redirect.
 //
 // If permanently is true, redirect to url with 301. (http.StatusMovedPermanently)
 // Otherwise redirect to url with 302. (http.StatusFound)
 func (c *Context) Redirect(url string, permanently bool) error {
 	if permanently {
-		c.Response.StatusCode = http.StatusMovedPermanently
-	} else {
-		c.Response.StatusCode = http.StatusFound
+		return c.RedirectWithStatus(url, http.StatusMovedPermanently)
 	}
-	http.Redirect(c.Response, c.Request.Request, url, c.Response.StatusCode)
+	return c.RedirectWithStatus(url, http.StatusFound)
+}
+
+// RedirectWithStatus renders result of redirect with the status code.
+// code must be one of 301, 302, 303, 307 and 308.
+func (c *Context) RedirectWithStatus(url string, code int) error {
+	if !isRedirectStatus(code) {
+		return fmt.Errorf("kocha: redirect: invalid status code: %v", code)
+	}
+	c.Response.StatusCode = code
+	http.Redirect(c.Response, c.Request.Request, url, code)
 	return nil
 }
 
+// Context returns the context.Context of the request.
+// It is cancelled when the client disconnects, the server shuts down, or the
+// timeout of Config.Timeout or Route.Timeout is exceeded. The long-running
+// operations such as the database queries should be given it.
+func (c *Context) Context() context.Context {
+	return c.Request.Context()
+}
+
 // Invoke is shorthand of c.App.Invoke.
 func (c *Context) Invoke(unit Unit, newFunc func(), defaultFunc func()) {
 	c.App.Invoke(unit, newFunc, defaultFunc)
@@ -399,6 +622,16 @@ func (c *Context) setContentTypeIfNotExists(contentType string) {
 	}
 }
 
+// setRenderedContentType sets contentType to the response for the renderer
+// that renders only contentType. It overrides the content type that has been
+// negotiated by ContentNegotiationMiddleware in order not to label the body
+// as the other type, but keeps the content type that the controller sets.
+func (c *Context) setRenderedContentType(contentType string) {
+	if c.Response.ContentType == c.negotiated {
+		c.Response.ContentType = contentType
+	}
+}
+
 func (c *Context) setData(data interface{}) error {
 	if data == nil {
 		return nil
@@ -445,6 +678,79 @@ func (c *Context) newParams() *Params {
 	return newParams(c, c.Request.Form, "")
 }
 
+// setTimeout sets the deadline of the request to d from now.
+// It replaces the deadline that has already been set.
+func (c *Context) setTimeout(d time.Duration) {
+	parent := c.ctx
+	if parent == nil {
+		parent = c.Request.Context()
+	}
+	ctx, cancel := context.WithTimeout(parent, d)
+	if prev := c.cancel; prev != nil {
+		c.cancel = func() {
+			prev()
+			cancel()
+		}
+	} else {
+		c.cancel = cancel
+	}
+	c.Request.Request = c.Request.WithContext(ctx)
+}
+
+// encodeElements encodes c.Data by enc. If c.Data is a slice, its elements
+// are encoded one by one with open, sep and close in order to write them
+// as soon as possible.
+func (c *Context) encodeElements(w io.Writer, enc Encoder, open, sep, close string) error {
+	if !isElements(c.Data) {
+		return enc.Encode(c.Data)
+	}
+	v := reflect.ValueOf(c.Data)
+	if _, err := io.WriteString(w, open); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, close)
+	return err
+}
+
+// isElements returns whether data is a slice that is encoded element by
+// element. A byte slice is encoded as a whole.
+func isElements(data interface{}) bool {
+	v := reflect.ValueOf(data)
+	return v.Kind() == reflect.Slice && !v.IsNil() && v.Type().Elem().Kind() != reflect.Uint8
+}
+
+// templateExists returns whether the template of c.Name and c.Format exists.
+// Note that Template.Get with the layout succeeds even if the template
+// doesn't exist.
+func (c *Context) templateExists() bool {
+	_, err := c.App.Template.Get(c.App.Config.AppName, "", c.Name, c.Format)
+	return err == nil
+}
+
+// logger returns the request-scoped logger.
+func (c *Context) logger() log.Logger {
+	return requestLogger(c.App, c)
+}
+
+// requestLogger returns the request-scoped logger of c.
+// If it hasn't been set, it returns the logger of app.
+func requestLogger(app *Application, c *Context) log.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return app.Logger
+}
+
 func (c *Context) errorWithLine(err error) error {
 	return errorWithLine(err, 3)
 }
@@ -456,6 +762,16 @@ func (c *Context) reset() {
 	c.Params = nil
 	c.Session = nil
 	c.Flash = nil
+	c.Logger = nil
+	c.route = nil
+	c.match = nil
+	c.rewrites = 0
+	c.csrfToken = ""
+	c.csrfFieldName = ""
+	c.cspNonce = ""
+	c.negotiated = ""
+	c.ctx = nil
+	c.cancel = nil
 }
 
 func (c *Context) reuse() {
@@ -491,3 +807,185 @@ type ErrorController struct {
 func (ec *ErrorController) GET(c *Context) error {
 	return c.RenderError(ec.StatusCode, nil, nil)
 }
+
+// RedirectController is generic controller for redirect to another URL.
+//
+// URL can contain the path parameters of the route such as "/users/:id" or
+// "https://example.com/*path", and they are filled by the values of the
+// request. If URL has no query string, the query string of the request is
+// preserved.
+// StatusCode must be one of 301, 302, 303, 307 and 308.
+// If StatusCode is 0, http.StatusMovedPermanently is used.
+type RedirectController struct {
+	*DefaultController
+
+	URL        string
+	StatusCode int
+}
+
+// Validate validates the settings of the controller.
+func (rc *RedirectController) Validate() error {
+	if rc.URL == "" {
+		return fmt.Errorf("kocha: redirect: URL must be specified")
+	}
+	if rc.StatusCode != 0 && !isRedirectStatus(rc.StatusCode) {
+		return fmt.Errorf("kocha: redirect: invalid status code: %v", rc.StatusCode)
+	}
+	return nil
+}
+
+func (rc *RedirectController) GET(c *Context) error {
+	return rc.redirect(c)
+}
+
+func (rc *RedirectController) HEAD(c *Context) error {
+	return rc.redirect(c)
+}
+
+func (rc *RedirectController) POST(c *Context) error {
+	return rc.redirect(c)
+}
+
+func (rc *RedirectController) PUT(c *Context) error {
+	return rc.redirect(c)
+}
+
+func (rc *RedirectController) PATCH(c *Context) error {
+	return rc.redirect(c)
+}
+
+func (rc *RedirectController) DELETE(c *Context) error {
+	return rc.redirect(c)
+}
+
+func (rc *RedirectController) redirect(c *Context) error {
+	status := rc.StatusCode
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+	u := fillRouteParams(rc.URL, c)
+	if q := c.Request.URL.RawQuery; q != "" && !strings.Contains(u, "?") {
+		u += "?" + q
+	}
+	return c.RedirectWithStatus(u, status)
+}
+
+// maxRewrites is the maximum number of the internal rewrites per request.
+const maxRewrites = 10
+
+// RewriteController is generic controller for dispatch the request to the
+// route of another path internally without a round-trip.
+//
+// Path can contain the path parameters of the route such as "/users/:id",
+// and they are filled by the values of the request.
+// The middlewares of the route of Path are processed, but the global
+// middlewares aren't processed again.
+type RewriteController struct {
+	*DefaultController
+
+	Path string
+}
+
+// Validate validates the settings of the controller.
+func (rc *RewriteController) Validate() error {
+	if !strings.HasPrefix(rc.Path, "/") {
+		return fmt.Errorf("kocha: rewrite: Path must start with `/', but %q", rc.Path)
+	}
+	return nil
+}
+
+func (rc *RewriteController) GET(c *Context) error {
+	return rc.rewrite(c)
+}
+
+func (rc *RewriteController) HEAD(c *Context) error {
+	return rc.rewrite(c)
+}
+
+func (rc *RewriteController) POST(c *Context) error {
+	return rc.rewrite(c)
+}
+
+func (rc *RewriteController) PUT(c *Context) error {
+	return rc.rewrite(c)
+}
+
+func (rc *RewriteController) PATCH(c *Context) error {
+	return rc.rewrite(c)
+}
+
+func (rc *RewriteController) DELETE(c *Context) error {
+	return rc.rewrite(c)
+}
+
+func (rc *RewriteController) rewrite(c *Context) error {
+	if c.rewrites++; c.rewrites > maxRewrites {
+		return fmt.Errorf("kocha: rewrite: too many rewrites: %v", c.Request.URL.Path)
+	}
+	path := fillRouteParams(rc.Path, c)
+	if c.route != nil {
+		for _, name := range c.route.paramNames {
+			c.Params.Del(name[1:])
+		}
+	}
+	u := *c.Request.URL
+	u.Path, u.RawPath = path, ""
+	c.Request.URL = &u
+	return c.App.dispatch(c)
+}
+
+// fillRouteParams returns s that the path parameters such as ":id" and
+// "*path" are replaced with the values of the path parameters of c.
+// The parameters that aren't the path parameters of the route are left as is.
+func fillRouteParams(s string, c *Context) string {
+	if c.route == nil {
+		return s
+	}
+	names := make(map[string]bool, len(c.route.paramNames))
+	for _, name := range c.route.paramNames {
+		names[name[1:]] = true
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch != ':' && ch != '*' {
+			buf = append(buf, ch)
+			continue
+		}
+		next := i + 1
+		for next < len(s) && isParamNameChar(s[next]) {
+			next++
+		}
+		name := s[i+1 : next]
+		if !names[name] {
+			buf = append(buf, ch)
+			continue
+		}
+		value := c.Params.Get(name)
+		if ch == '*' {
+			segments := strings.Split(value, "/")
+			for j, segment := range segments {
+				segments[j] = url.PathEscape(segment)
+			}
+			value = strings.Join(segments, "/")
+		} else {
+			value = url.PathEscape(value)
+		}
+		buf = append(buf, value...)
+		i = next - 1
+	}
+	return string(buf)
+}
+
+func isParamNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// isRedirectStatus returns whether the code is the status code of redirect.
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
@@ -14,23 +15,27 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ugorji/go/codec"
 	"github.com/woremacx/kocha"
 	"github.com/woremacx/kocha/log"
 )
 
 func TestMimeTypeFormats(t *testing.T) {
 	var actual interface{} = len(kocha.MimeTypeFormats)
-	var expected interface{} = 4
+	var expected interface{} = 6
 	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf(`len(TestMimeTypeFormats) => %#v; want %#v`, actual, expected)
 	}
 	for k, v := range map[string]string{
 
-		"application/json": "json",
-		"application/xml":  "xml",
-		"text/html":        "html",
-		"text/plain":       "txt",
+		"application/json":      "json",
+		"application/x-msgpack": "msgpack",
+		"application/xml":       "xml",
+		"text/csv":              "csv",
+		"text/html":             "html",
+		"text/plain":            "txt",
 	} {
 		if _, found := kocha.MimeTypeFormats[k]; !found {
 			t.Errorf(`MimeTypeFormats["%#v"] => notfound; want %v`, k, v)
@@ -406,6 +411,274 @@ func TestContext_RenderXML(t *testing.T) {
 	}
 }
 
+type testMarshaler struct{}
+
+func (m *testMarshaler) Marshal(v interface{}, opts kocha.MarshalOptions) ([]byte, error) {
+	return []byte(fmt.Sprintf("marshal:%v", v)), nil
+}
+
+func (m *testMarshaler) NewEncoder(w io.Writer, opts kocha.MarshalOptions) kocha.Encoder {
+	return nil
+}
+
+func TestContext_RenderJSON_withConfig(t *testing.T) {
+	type data struct {
+		A string
+	}
+	for _, v := range []struct {
+		config      *kocha.JSONConfig
+		uri         string
+		data        interface{}
+		status      int
+		contentType string
+		expect      string
+	}{
+		{&kocha.JSONConfig{}, "/", data{"<b>"}, http.StatusOK, "application/json", `{"A":"\u003cb\u003e"}`},
+		{&kocha.JSONConfig{DisableHTMLEscape: true}, "/", data{"<b>"}, http.StatusOK, "application/json", `{"A":"<b>"}`},
+		{&kocha.JSONConfig{Indent: "  "}, "/", data{"a"}, http.StatusOK, "application/json", "{\n  \"A\": \"a\"\n}"},
+		{&kocha.JSONConfig{}, "/?callback=cb", data{"a"}, http.StatusOK, "application/json", `{"A":"a"}`},
+		{&kocha.JSONConfig{JSONPCallbackParam: "callback"}, "/", data{"a"}, http.StatusOK, "application/json", `{"A":"a"}`},
+		{&kocha.JSONConfig{JSONPCallbackParam: "callback"}, "/?callback=jQuery.cb_1", data{"a"}, http.StatusOK, "application/javascript", `/**/jQuery.cb_1({"A":"a"});`},
+		{&kocha.JSONConfig{JSONPCallbackParam: "callback"}, "/?callback=alert(1)//", data{"a"}, http.StatusBadRequest, "text/html", "400 error\n"},
+		{&kocha.JSONConfig{JSONPCallbackParam: "callback"}, "/?callback=" + strings.Repeat("a", 129), data{"a"}, http.StatusBadRequest, "text/html", "400 error\n"},
+		{&kocha.JSONConfig{Stream: true}, "/", []data{{"a"}, {"b"}}, http.StatusOK, "application/json", "[{\"A\":\"a\"}\n,{\"A\":\"b\"}\n]"},
+		{&kocha.JSONConfig{Stream: true}, "/", []data(nil), http.StatusOK, "application/json", "null\n"},
+		{&kocha.JSONConfig{Stream: true, JSONPCallbackParam: "callback"}, "/?callback=cb", data{"a"}, http.StatusOK, "application/javascript", "/**/cb({\"A\":\"a\"}\n);"},
+		{&kocha.JSONConfig{Marshaler: &testMarshaler{}}, "/", "a", http.StatusOK, "application/json", "marshal:a"},
+	} {
+		c := newTestContext("testctrlr", "")
+		c.App.Config.JSON = v.config
+		if v.config.Marshaler == nil {
+			v.config.Marshaler = &kocha.JSONMarshaler{}
+		}
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Request = &kocha.Request{Request: req}
+		w := httptest.NewRecorder()
+		c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+		if err := c.RenderJSON(v.data); err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := []interface{}{w.Code, w.Header().Get("Content-Type"), w.Body.String()}
+		expect := []interface{}{v.status, v.contentType, v.expect}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RenderJSON(%#v) with %#v and %#v => %#v; want %#v`, v.data, v.config, v.uri, actual, expect)
+		}
+	}
+}
+
+func TestContext_RenderXML_withConfig(t *testing.T) {
+	type user struct {
+		XMLName xml.Name `xml:"user"`
+		ID      string   `xml:"id"`
+	}
+	for _, v := range []struct {
+		config *kocha.XMLConfig
+		data   interface{}
+		expect string
+	}{
+		{&kocha.XMLConfig{}, user{ID: "a"}, "<user><id>a</id></user>"},
+		{&kocha.XMLConfig{Header: true, Indent: "  "}, user{ID: "a"}, xml.Header + "<user>\n  <id>a</id>\n</user>"},
+		{&kocha.XMLConfig{Stream: true, StreamRoot: "users"}, []user{{ID: "a"}, {ID: "b"}}, "<users><user><id>a</id></user><user><id>b</id></user></users>"},
+		{&kocha.XMLConfig{Stream: true, StreamRoot: "users"}, []user(nil), ""},
+		{&kocha.XMLConfig{Stream: true, Header: true}, user{ID: "a"}, xml.Header + "<user><id>a</id></user>"},
+		{&kocha.XMLConfig{Marshaler: &testMarshaler{}}, "a", "marshal:a"},
+	} {
+		c := newTestContext("testctrlr", "")
+		c.App.Config.XML = v.config
+		if v.config.Marshaler == nil {
+			v.config.Marshaler = &kocha.XMLMarshaler{}
+		}
+		w := httptest.NewRecorder()
+		c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+		if err := c.RenderXML(v.data); err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := []interface{}{w.Header().Get("Content-Type"), w.Body.String()}
+		expect := []interface{}{"application/xml", v.expect}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RenderXML(%#v) with %#v => %#v; want %#v`, v.data, v.config, actual, expect)
+		}
+	}
+}
+
+func TestContext_RenderXML_withStreamSlice(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	c.App.Config.XML = &kocha.XMLConfig{Stream: true, Marshaler: &kocha.XMLMarshaler{}}
+	w := httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+	err := c.RenderXML([]string{"a", "b"})
+	var actual interface{} = err != nil && strings.HasSuffix(err.Error(), "kocha: xml: XMLConfig.StreamRoot must be set to stream a slice")
+	var expect interface{} = true
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RenderXML([]string{"a", "b"}) with Stream => %#v; want the error of StreamRoot`, err)
+	}
+	actual = []interface{}{c.Response.IsStreaming(), w.Body.String()}
+	expect = []interface{}{false, ""}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RenderXML([]string{"a", "b"}) with Stream => %#v; want %#v`, actual, expect)
+	}
+}
+
+type testErrorResponseWriter struct {
+	*httptest.ResponseRecorder
+	n int
+}
+
+func (w *testErrorResponseWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, fmt.Errorf("write error")
+	}
+	w.n--
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestContext_RenderJSON_withStreamError(t *testing.T) {
+	for _, v := range []struct {
+		uri    string
+		writes int
+		expect string
+	}{
+		{"/?callback=cb", 0, ""},
+		{"/", 1, ""},
+		{"/", 2, "["},
+		{"/", 3, "[\"a\"\n"},
+		{"/", 4, "[\"a\"\n,"},
+		{"/", 5, "[\"a\"\n,\"b\"\n"},
+		{"/", 6, "[\"a\"\n,\"b\"\n]"},
+	} {
+		c := newTestContext("testctrlr", "")
+		c.App.Config.JSON = &kocha.JSONConfig{Stream: true, JSONPCallbackParam: "callback", Marshaler: &kocha.JSONMarshaler{}}
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Request = &kocha.Request{Request: req}
+		w := &testErrorResponseWriter{ResponseRecorder: httptest.NewRecorder(), n: v.writes}
+		c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+		err = c.RenderJSON([]string{"a", "b"})
+		var actual interface{} = err != nil && strings.HasSuffix(err.Error(), "write error")
+		var expect interface{} = true
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RenderJSON([]string{"a", "b"}) with %#v and %v writes => %#v; want the write error`, v.uri, v.writes, err)
+		}
+		actual = w.Body.String()
+		expect = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RenderJSON([]string{"a", "b"}) with %#v and %v writes => %#v; want %#v`, v.uri, v.writes, actual, expect)
+		}
+	}
+}
+
+func TestContext_RenderMsgpack(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	w := httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+	if err := c.RenderMsgpack(map[string]interface{}{"a": "hoge", "b": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var h codec.MsgpackHandle
+	h.RawToString = true
+	var actual interface{}
+	if err := codec.NewDecoder(w.Body, &h).Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	var expected interface{} = map[interface{}]interface{}{"a": "hoge", "b": int64(1)}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`RenderMsgpack(data); decoded body => %#v; want %#v`, actual, expected)
+	}
+	actual = w.Header().Get("Content-Type")
+	expected = "application/x-msgpack"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`RenderMsgpack(data); Content-Type => %#v; want %#v`, actual, expected)
+	}
+}
+
+func TestContext_RenderCSV(t *testing.T) {
+	type user struct {
+		ID       int    `csv:"id"`
+		Name     string `csv:"name"`
+		Password string `csv:"-"`
+		Email    *string
+		note     string
+	}
+	email := "alice@example.com"
+	for _, v := range []struct {
+		data   interface{}
+		expect string
+	}{
+		{[][]string{{"id", "name"}, {"1", "a,b"}, {"2", `"c"`}}, "id,name\n1,\"a,b\"\n2,\"\"\"c\"\"\"\n"},
+		{[]user{{1, "alice", "secret", &email, "note"}, {2, "bob", "secret", nil, ""}}, "id,name,Email\n1,alice,alice@example.com\n2,bob,\n"},
+		{[]*user{{ID: 1, Name: "alice"}, nil}, "id,name,Email\n1,alice,\n,,\n"},
+		{[]user{}, "id,name,Email\n"},
+	} {
+		c := newTestContext("testctrlr", "")
+		w := httptest.NewRecorder()
+		c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+		if err := c.RenderCSV(v.data); err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := []interface{}{w.Header().Get("Content-Type"), w.Body.String()}
+		expect := []interface{}{"text/csv", v.expect}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RenderCSV(%#v) => %#v; want %#v`, v.data, actual, expect)
+		}
+	}
+
+	c := newTestContext("testctrlr", "")
+	c.Response = &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: http.StatusOK}
+	err := c.RenderCSV([]string{"a"})
+	suffix := "kocha: csv: unsupported type: []string"
+	if err == nil || !strings.HasSuffix(err.Error(), suffix) {
+		t.Errorf(`RenderCSV([]string{"a"}) => %#v; want error with suffix %#v`, err, suffix)
+	}
+}
+
+type testRenderer struct{}
+
+func (r *testRenderer) Render(w io.Writer, data interface{}) error {
+	_, err := fmt.Fprintf(w, "rendered:%v", data)
+	return err
+}
+
+func TestContext_RenderAs(t *testing.T) {
+	kocha.RegisterRenderer("application/x-test", &testRenderer{})
+	defer kocha.RegisterRenderer("application/x-test", nil)
+	c := newTestContext("testctrlr", "")
+	w := httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+	if err := c.RenderAs("application/x-test", "hoge"); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = []interface{}{w.Header().Get("Content-Type"), w.Body.String()}
+	var expected interface{} = []interface{}{"application/x-test", "rendered:hoge"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`RenderAs("application/x-test", "hoge") => %#v; want %#v`, actual, expected)
+	}
+
+	c = newTestContext("testctrlr", "")
+	c.Response = &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: http.StatusOK}
+	err := c.RenderAs("application/x-unknown", "hoge")
+	suffix := "kocha: renderer isn't registered for application/x-unknown"
+	if err == nil || !strings.HasSuffix(err.Error(), suffix) {
+		t.Errorf(`RenderAs("application/x-unknown", "hoge") => %#v; want error with suffix %#v`, err, suffix)
+	}
+
+	kocha.RegisterRenderer("application/x-test", nil)
+	c = newTestContext("testctrlr", "")
+	c.Response = &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: http.StatusOK}
+	err = c.RenderAs("application/x-test", "hoge")
+	suffix = "kocha: renderer isn't registered for application/x-test"
+	if err == nil || !strings.HasSuffix(err.Error(), suffix) {
+		t.Errorf(`RenderAs("application/x-test", "hoge") after unregistering => %#v; want error with suffix %#v`, err, suffix)
+	}
+}
+
 func TestContext_RenderText(t *testing.T) {
 	c := newTestContext("testctrlr", "")
 	w := httptest.NewRecorder()
@@ -674,6 +947,131 @@ func TestContext_SendFile(t *testing.T) {
 	}()
 }
 
+func TestContext_SendFile_withLastModified(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "TestContextSendFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	modtime := time.Date(2014, 4, 1, 12, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(tmpFile.Name(), modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestContext("testctrlr", "")
+	w := httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+	if err := c.SendFile(tmpFile.Name()); err != nil {
+		t.Fatal(err)
+	}
+	actual := w.Header().Get("Last-Modified")
+	expected := "Tue, 01 Apr 2014 12:30:00 GMT"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`SendFile(%#v); Last-Modified => %#v; want %#v`, tmpFile.Name(), actual, expected)
+	}
+
+	c = newTestContext("testctrlr", "")
+	c.App.ResourceSet.Add("testrcname", "foobarbaz")
+	c.App.Config.BuildTime = time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	w = httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+	if err := c.SendFile("testrcname"); err != nil {
+		t.Fatal(err)
+	}
+	actual = w.Header().Get("Last-Modified")
+	expected = "Fri, 02 Jan 2015 03:04:05 GMT"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`SendFile("testrcname"); Last-Modified => %#v; want %#v`, actual, expected)
+	}
+}
+
+func TestContext_Stream(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	w := httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusAccepted}
+	c.Response.Header().Set("X-Test", "stream")
+	var flushed []bool
+	if err := c.Stream(func(sw io.Writer) error {
+		flushed = append(flushed, w.Flushed)
+		for _, s := range []string{"foo", "bar"} {
+			if _, err := io.WriteString(sw, s); err != nil {
+				return err
+			}
+			sw.(http.Flusher).Flush()
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = []interface{}{w.Code, w.Header().Get("Content-Type"), w.Header().Get("X-Test"), w.Body.String(), flushed}
+	var expected interface{} = []interface{}{http.StatusAccepted, "application/octet-stream", "stream", "foobar", []bool{true}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Context.Stream(fn) => %#v; want %#v`, actual, expected)
+	}
+	actual = c.Response.IsStreaming()
+	expected = true
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Context.Stream(fn); Response.IsStreaming() => %#v; want %#v`, actual, expected)
+	}
+
+	err := c.Stream(func(sw io.Writer) error { return nil })
+	actual = err
+	expected = fmt.Errorf("kocha: stream: response has already been streamed")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Context.Stream(fn) twice => %#v; want %#v`, actual, expected)
+	}
+}
+
+type testStreamCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testStreamCtrl) GET(c *kocha.Context) error {
+	c.Response.ContentType = "text/csv"
+	return c.Stream(func(w io.Writer) error {
+		for i := 0; i < 1000; i++ {
+			if _, err := fmt.Fprintf(w, "%d,row\n", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestContext_Stream_withMiddlewares(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name:       "stream",
+		Path:       "/stream",
+		Controller: &testStreamCtrl{},
+	})
+	config.Middlewares = []kocha.Middleware{
+		&kocha.ETagMiddleware{},
+		&kocha.CompressMiddleware{ContentTypes: []string{"text/csv"}},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "%d,row\n", i)
+	}
+	var actual interface{} = []interface{}{w.Code, w.Header().Get("Content-Type"), w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), w.Body.String()}
+	var expected interface{} = []interface{}{http.StatusOK, "text/csv", "", "", buf.String()}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`GET "/stream" => %#v; want %#v`, actual, expected)
+	}
+}
+
 func TestContext_Redirect(t *testing.T) {
 	c := newTestContext("testctrlr", "")
 	for _, v := range []struct {
@@ -696,3 +1094,37 @@ func TestContext_Redirect(t *testing.T) {
 		}
 	}
 }
+
+func TestContext_RedirectWithStatus(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	for _, v := range []struct {
+		redirectURL string
+		status      int
+	}{
+		{"/path/to/redirect/moved_permanently", http.StatusMovedPermanently},
+		{"/path/to/redirect/found", http.StatusFound},
+		{"/path/to/redirect/see_other", http.StatusSeeOther},
+		{"/path/to/redirect/temporary", http.StatusTemporaryRedirect},
+		{"/path/to/redirect/permanent", http.StatusPermanentRedirect},
+	} {
+		w := httptest.NewRecorder()
+		c.Response = &kocha.Response{ResponseWriter: w}
+		if err := c.RedirectWithStatus(v.redirectURL, v.status); err != nil {
+			t.Fatal(err)
+		}
+		actual := []interface{}{w.Code, w.HeaderMap.Get("Location")}
+		expected := []interface{}{v.status, v.redirectURL}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`Controller.RedirectWithStatus("%#v", %#v) => %#v; want %#v`, v.redirectURL, v.status, actual, expected)
+		}
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusMultipleChoices, http.StatusNotModified, http.StatusUseProxy, 306, http.StatusNotFound} {
+		c.Response = &kocha.Response{ResponseWriter: httptest.NewRecorder()}
+		actual := c.RedirectWithStatus("/", status)
+		expect := fmt.Errorf("kocha: redirect: invalid status code: %v", status)
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`Controller.RedirectWithStatus("/", %#v) => %#v; want %#v`, status, actual, expect)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package kocha
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/woremacx/kocha/event/memory"
+)
+
+func TestEvent_Trigger_withSSEBroker(t *testing.T) {
+	var broker SSEBroker
+	config := NewTestApp().Config
+	config.Event = &Event{
+		HandlerMap: EventHandlerMap{
+			&memory.EventQueue{}: {
+				"notify": func(app *Application, args ...interface{}) error {
+					broker.Publish(&SSEEvent{Event: "notify", Data: args[0].(string)})
+					return nil
+				},
+			},
+		},
+	}
+	app, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+	app.startEvent()
+	defer app.stopEvent()
+	if err := app.Event.Trigger("notify", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-events:
+		expect := &SSEEvent{Event: "notify", Data: "hello"}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`Event.Trigger("notify", "hello"); received event => %#v; want %#v`, actual, expect)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf(`Event.Trigger("notify", "hello"); no event has been received`)
+	}
+}
@@ -2,12 +2,17 @@ package kocha
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"reflect"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/woremacx/kocha/log"
@@ -57,14 +62,15 @@ func Run(config *Config) error {
 			app.Logger.Warn("kocha: graceful restarted")
 		case miyabi.StateShutdown:
 			app.Logger.Warn("kocha: graceful shutdown")
+			app.shutdown()
 		}
 	}
 	server := &miyabi.Server{
 		Addr:    config.Addr,
 		Handler: app,
 	}
-	app.Event.start()
-	defer app.Event.stop()
+	app.startEvent()
+	defer app.stopEvent()
 	return server.ListenAndServe()
 }
 
@@ -89,8 +95,12 @@ type Application struct {
 	// ResourceSet is set of resource of an application.
 	ResourceSet ResourceSet
 
-	failedUnits map[string]struct{}
-	mu          sync.RWMutex
+	failedUnits    map[string]struct{}
+	mounts         []*Mount
+	trustedProxies []*net.IPNet
+	ctx            context.Context // cancelled on shutdown of the server.
+	cancel         context.CancelFunc
+	mu             sync.RWMutex
 }
 
 // New returns a new Application that configured by config.
@@ -99,6 +109,7 @@ func New(config *Config) (*Application, error) {
 		Config:      config,
 		failedUnits: make(map[string]struct{}),
 	}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 	if app.Config.Addr == "" {
 		config.Addr = DefaultHttpAddr
 	}
@@ -108,6 +119,9 @@ func New(config *Config) (*Application, error) {
 	if err := app.validateMiddlewares(); err != nil {
 		return nil, err
 	}
+	if err := app.buildTrustedProxies(); err != nil {
+		return nil, err
+	}
 	if err := app.buildResourceSet(); err != nil {
 		return nil, err
 	}
@@ -120,33 +134,105 @@ func New(config *Config) (*Application, error) {
 	if err := app.buildLogger(); err != nil {
 		return nil, err
 	}
+	if err := app.buildRenderConfig(); err != nil {
+		return nil, err
+	}
 	if err := app.buildEvent(); err != nil {
 		return nil, err
 	}
+	if err := app.buildMounts(); err != nil {
+		return nil, err
+	}
+	app.mountApps()
 	return app, nil
 }
 
 // ServeHTTP implements the http.Handler.ServeHTTP.
 func (app *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, m := range app.mounts {
+		if req, ok := m.strip(r); ok {
+			m.App.ServeHTTP(w, req)
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	stop := context.AfterFunc(app.ctx, cancel)
 	c := newContext()
 	c.Layout = app.Config.DefaultLayout
-	c.Request = newRequest(r)
-	c.Response = newResponse()
+	c.Request = newRequest(r.WithContext(ctx), app.trustedProxies)
+	c.Response = newResponse(w)
 	c.App = app
+	c.Logger = app.Logger
 	c.Errors = make(map[string][]*ParamError)
+	c.ctx = ctx
 	defer c.reuse()
+	defer func() {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		stop()
+		cancel()
+	}()
+	if app.Config.Timeout > 0 {
+		c.setTimeout(app.Config.Timeout)
+	}
 	defer func() {
 		if err := c.Response.writeTo(w); err != nil {
-			app.Logger.Error(err)
+			c.Logger.Error(err)
 		}
 	}()
-	if err := app.wrapMiddlewares(c)(); err != nil {
-		app.Logger.Error(err)
+	if err := app.wrapMiddlewares(c, app.Config.Middlewares, nullMiddlewareNext)(); err != nil {
+		c.Logger.Error(err)
 		c.Response.reset()
 		http.Error(c.Response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
+// lookup returns the route for the request of c.
+// The result is cached in c until the URL path is changed, so that the
+// middlewares such as RateLimitMiddleware can find the route before
+// DispatchMiddleware without routing twice.
+func (app *Application) lookup(c *Context) *routeMatch {
+	if c.match == nil || c.match.path != c.Request.URL.Path {
+		route, handler, params, found := app.Router.dispatch(c.Request)
+		c.match = &routeMatch{
+			path:    c.Request.URL.Path,
+			route:   route,
+			handler: handler,
+			params:  params,
+			found:   found,
+		}
+	}
+	return c.match
+}
+
+// dispatch dispatches the request of c to the handler of the route.
+func (app *Application) dispatch(c *Context) error {
+	m := app.lookup(c)
+	route, handler, params, found := m.route, m.handler, m.params, m.found
+	if !found {
+		handler = (&ErrorController{
+			StatusCode: http.StatusNotFound,
+		}).GET
+	}
+	if c.Params == nil {
+		c.Params = c.newParams()
+	}
+	for _, param := range params {
+		c.Params.Add(param.Name, param.Value)
+	}
+	if route == nil {
+		return handler(c)
+	}
+	c.Name, c.route = route.Name, route
+	if route.Timeout > 0 {
+		c.setTimeout(route.Timeout)
+	}
+	return app.wrapMiddlewares(c, route.middlewares, func() error {
+		return handler(c)
+	})()
+}
+
 // Invoke invokes newFunc.
 // It invokes newFunc but will behave to fallback.
 // When unit.ActiveIf returns false or any errors occurred in invoking, it invoke the defaultFunc if defaultFunc isn't nil.
@@ -156,7 +242,7 @@ func (app *Application) Invoke(unit Unit, newFunc func(), defaultFunc func()) {
 	defer func() {
 		if err := recover(); err != nil {
 			if err != ErrInvokeDefault {
-				app.logStackAndError(err)
+				logStackAndError(app.Logger, err)
 				app.mu.Lock()
 				app.failedUnits[name] = struct{}{}
 				app.mu.Unlock()
@@ -176,10 +262,50 @@ func (app *Application) Invoke(unit Unit, newFunc func(), defaultFunc func()) {
 }
 
 func (app *Application) buildRouter() (err error) {
-	app.Router, err = app.Config.RouteTable.buildRouter()
+	if app.Router, err = app.Config.RouteTable.buildRouter(); err != nil {
+		return err
+	}
+	app.Router.name = app.Config.AppName
+	return nil
+}
+
+func (app *Application) buildTrustedProxies() (err error) {
+	app.trustedProxies, err = parseTrustedProxies(app.Config.TrustedProxies)
 	return err
 }
 
+func (app *Application) buildMounts() error {
+	app.mounts = make([]*Mount, len(app.Config.Mounts))
+	mounted := make(map[*Application]bool, len(app.Config.Mounts))
+	for i, m := range app.Config.Mounts {
+		if m == nil || m.App == nil {
+			return fmt.Errorf("kocha: mount: App must be specified")
+		}
+		prefix := strings.TrimSuffix(m.Prefix, "/")
+		if prefix == "" || prefix[0] != '/' {
+			return fmt.Errorf("kocha: mount: Prefix must start with `/', but %q", m.Prefix)
+		}
+		if m.App.Router.parent != nil || mounted[m.App] {
+			return fmt.Errorf("kocha: mount: %v has already been mounted", m.App.Config.AppName)
+		}
+		mounted[m.App] = true
+		app.mounts[i] = &Mount{Prefix: prefix, App: m.App}
+	}
+	// the longest prefix takes precedence.
+	sort.Sort(sort.Reverse(mountsByPrefixLen(app.mounts)))
+	return nil
+}
+
+// mountApps links the routers of the mounted applications to the router of
+// app. It must be called after all of the build steps have succeeded, so that
+// the applications can be mounted again if New fails.
+func (app *Application) mountApps() {
+	for _, m := range app.mounts {
+		m.App.Router.prefix, m.App.Router.parent = m.Prefix, app.Router
+		app.Router.mounts = append(app.Router.mounts, m.App.Router)
+	}
+}
+
 func (app *Application) buildResourceSet() error {
 	app.ResourceSet = app.Config.ResourceSet
 	return nil
@@ -204,26 +330,63 @@ func (app *Application) buildLogger() error {
 	return nil
 }
 
+func (app *Application) buildRenderConfig() error {
+	if app.Config.JSON == nil {
+		app.Config.JSON = &JSONConfig{}
+	}
+	if app.Config.JSON.Marshaler == nil {
+		app.Config.JSON.Marshaler = &JSONMarshaler{}
+	}
+	if app.Config.XML == nil {
+		app.Config.XML = &XMLConfig{}
+	}
+	if app.Config.XML.Marshaler == nil {
+		app.Config.XML.Marshaler = &XMLMarshaler{}
+	}
+	return nil
+}
+
 func (app *Application) buildEvent() (err error) {
 	app.Event, err = app.Config.Event.build(app)
 	return err
 }
 
 func (app *Application) validateMiddlewares() error {
-	for _, m := range app.Config.Middlewares {
-		if v, ok := m.(Validator); ok {
-			if err := v.Validate(); err != nil {
-				return err
+	if err := validateMiddlewares(app.Config.Middlewares, false); err != nil {
+		return err
+	}
+	// the per-route middlewares are processed after all the global
+	// middlewares that precede DispatchMiddleware.
+	afterSession := hasSessionMiddleware(app.Config.Middlewares)
+	validated := make(map[*RouteGroup]bool)
+	for _, route := range app.Config.RouteTable {
+		var groups []*RouteGroup
+		for g := route.Group; g != nil; g = g.Parent {
+			groups = append([]*RouteGroup{g}, groups...)
+		}
+		routeAfterSession := afterSession
+		for _, g := range groups {
+			if !validated[g] {
+				if err := validateMiddlewares(g.Middlewares, routeAfterSession); err != nil {
+					return err
+				}
+				validated[g] = true
 			}
+			routeAfterSession = routeAfterSession || hasSessionMiddleware(g.Middlewares)
+		}
+		if err := validateMiddlewares(route.Middlewares, routeAfterSession); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (app *Application) wrapMiddlewares(c *Context) func() error {
-	wrapped := nullMiddlewareNext
-	for i := len(app.Config.Middlewares) - 1; i >= 0; i-- {
-		f, next := app.Config.Middlewares[i].Process, wrapped
+// wrapMiddlewares returns a function that processes the middlewares in order,
+// and then calls last.
+func (app *Application) wrapMiddlewares(c *Context, middlewares []Middleware, last func() error) func() error {
+	wrapped := last
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f, next := middlewares[i].Process, wrapped
 		wrapped = func() error {
 			return f(app, c, next)
 		}
@@ -231,10 +394,63 @@ func (app *Application) wrapMiddlewares(c *Context) func() error {
 	return wrapped
 }
 
-func (app *Application) logStackAndError(err interface{}) {
+// validateMiddlewares validates the middlewares in order.
+// afterSession reports whether SessionMiddleware has been processed before
+// the middlewares.
+func validateMiddlewares(middlewares []Middleware, afterSession bool) error {
+	for _, m := range middlewares {
+		switch m := m.(type) {
+		case *SessionMiddleware:
+			afterSession = true
+		case *CSRFMiddleware:
+			if m != nil && !afterSession {
+				return fmt.Errorf("kocha: csrf: CSRFMiddleware must be placed after SessionMiddleware")
+			}
+		}
+		if v, ok := m.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasSessionMiddleware(middlewares []Middleware) bool {
+	for _, m := range middlewares {
+		if _, ok := m.(*SessionMiddleware); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *Application) startEvent() {
+	app.Event.start()
+	for _, m := range app.mounts {
+		m.App.startEvent()
+	}
+}
+
+func (app *Application) stopEvent() {
+	for _, m := range app.mounts {
+		m.App.stopEvent()
+	}
+	app.Event.stop()
+}
+
+// shutdown cancels the contexts of the requests in process.
+func (app *Application) shutdown() {
+	for _, m := range app.mounts {
+		m.App.shutdown()
+	}
+	app.cancel()
+}
+
+func logStackAndError(logger log.Logger, err interface{}) {
 	buf := make([]byte, 4096)
 	n := runtime.Stack(buf, false)
-	app.Logger.Errorf("%v\n%s", err, buf[:n])
+	logger.Errorf("%v\n%s", err, buf[:n])
 }
 
 // Config represents a application-scope configuration.
@@ -249,10 +465,69 @@ type Config struct {
 	Logger            *LoggerConfig // logger config.
 	Event             *Event        // event config.
 	MaxClientBodySize int64         // maximum size of request body, DefaultMaxClientBodySize if 0
+	Timeout           time.Duration // timeout of each request, no timeout if 0. See TimeoutMiddleware.
+	TrustedProxies    []string      // CIDRs or IPs of the proxies that are trusted to forward the client information.
+	Mounts            []*Mount      // sub-applications that are mounted under the path prefixes.
+	JSON              *JSONConfig   // config of Context.RenderJSON.
+	XML               *XMLConfig    // config of Context.RenderXML.
+	BuildTime         time.Time     // time when the application was built by `kocha build'.
 
 	ResourceSet ResourceSet
 }
 
+// Mount represents a sub-application that is mounted under a path prefix.
+//
+// The requests under Prefix are handled by App entirely with the path that
+// Prefix is stripped. So App keeps its own templates, middlewares, logger
+// and ResourceSet.
+// The routes of App can be reversed from the other applications by the name
+// that is qualified by the AppName of App such as "admin:user".
+type Mount struct {
+	Prefix string       // path prefix. e.g. "/admin"
+	App    *Application // application to mount.
+}
+
+// strip returns a copy of r that Prefix is stripped from the URL path.
+// If the URL path of r isn't under Prefix, it returns false.
+func (m *Mount) strip(r *http.Request) (*http.Request, bool) {
+	path := r.URL.Path
+	if !strings.HasPrefix(path, m.Prefix) {
+		return nil, false
+	}
+	path = path[len(m.Prefix):]
+	switch {
+	case path == "":
+		path = "/"
+	case path[0] != '/':
+		return nil, false
+	}
+	req := new(http.Request)
+	*req = *r
+	u := *r.URL
+	u.Path = path
+	u.RawPath = ""
+	req.URL = &u
+	return req, true
+}
+
+// mountsByPrefixLen implements sort.Interface interface.
+type mountsByPrefixLen []*Mount
+
+// Len implements sort.Interface.Len.
+func (ms mountsByPrefixLen) Len() int {
+	return len(ms)
+}
+
+// Less implements sort.Interface.Less.
+func (ms mountsByPrefixLen) Less(i, j int) bool {
+	return len(ms[i].Prefix) < len(ms[j].Prefix)
+}
+
+// Swap implements sort.Interface.Swap.
+func (ms mountsByPrefixLen) Swap(i, j int) {
+	ms[i], ms[j] = ms[j], ms[i]
+}
+
 // Getenv is similar to os.Getenv.
 // However, Getenv returns def value if the variable is not present, and
 // sets def to environment variable.
@@ -2,6 +2,7 @@ package kocha_test
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -418,6 +419,457 @@ func TestApplication_ServeHTTP_withPOST(t *testing.T) {
 	}()
 }
 
+func TestApplication_ServeHTTP_withRouteGroup(t *testing.T) {
+	var called []string
+	admin := &kocha.RouteGroup{
+		Prefix:      "/admin",
+		Middlewares: []kocha.Middleware{&TestMiddleware{t: t, id: "Admin", called: &called}},
+	}
+	api := &kocha.RouteGroup{
+		Prefix:      "/api",
+		Middlewares: []kocha.Middleware{&TestMiddleware{t: t, id: "API", called: &called}},
+		Parent:      admin,
+	}
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable,
+		&kocha.Route{Name: "admin_root", Path: "/", Controller: &testRouteNameCtrl{}, Group: admin},
+		&kocha.Route{Name: "admin_api_item", Path: "/item/:id", Controller: &testRouteNameCtrl{}, Group: api},
+	)
+	config.Middlewares = []kocha.Middleware{
+		&TestMiddleware{t: t, id: "Global", called: &called},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri    string
+		status int
+		body   string
+		called []string
+	}{
+		{"/", http.StatusOK, "This is layout\nThis is root\n\n", []string{"beforeGlobal", "afterGlobal"}},
+		{"/admin/", http.StatusOK, "admin_root", []string{"beforeGlobal", "beforeAdmin", "afterAdmin", "afterGlobal"}},
+		{"/admin/api/item/1", http.StatusOK, "admin_api_item:1", []string{"beforeGlobal", "beforeAdmin", "beforeAPI", "afterAPI", "afterAdmin", "afterGlobal"}},
+		{"/admin/missing", http.StatusNotFound, "This is layout\n404 template not found\n\n", []string{"beforeGlobal", "afterGlobal"}},
+	} {
+		called = nil
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v status => %#v; want %#v`, v.uri, actual, expect)
+		}
+
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v => %#v; want %#v`, v.uri, actual, expect)
+		}
+
+		actual = called
+		expect = v.called
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with middlewares calls => %#v; want %#v`, v.uri, actual, expect)
+		}
+	}
+}
+
+func TestApplication_ServeHTTP_withRouteMiddlewares(t *testing.T) {
+	var called []string
+	group := &kocha.RouteGroup{
+		Prefix:      "/group",
+		Middlewares: []kocha.Middleware{&TestMiddleware{t: t, id: "Group", called: &called}},
+	}
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable,
+		&kocha.Route{
+			Name:        "route_middleware",
+			Path:        "/route_middleware",
+			Controller:  &testRouteNameCtrl{},
+			Middlewares: []kocha.Middleware{&TestMiddleware{t: t, id: "Route", called: &called}},
+		},
+		&kocha.Route{
+			Name:        "group_route_middleware",
+			Path:        "/route_middleware",
+			Controller:  &testRouteNameCtrl{},
+			Middlewares: []kocha.Middleware{&TestMiddleware{t: t, id: "Route", called: &called}},
+			Group:       group,
+		},
+	)
+	config.Middlewares = []kocha.Middleware{
+		&TestMiddleware{t: t, id: "Global", called: &called},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri    string
+		body   string
+		called []string
+	}{
+		{"/route_middleware", "route_middleware", []string{"beforeGlobal", "beforeRoute", "afterRoute", "afterGlobal"}},
+		{"/group/route_middleware", "group_route_middleware", []string{"beforeGlobal", "beforeGroup", "beforeRoute", "afterRoute", "afterGroup", "afterGlobal"}},
+	} {
+		called = nil
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		var actual interface{} = w.Body.String()
+		var expect interface{} = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v => %#v; want %#v`, v.uri, actual, expect)
+		}
+
+		actual = called
+		expect = v.called
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with middlewares calls => %#v; want %#v`, v.uri, actual, expect)
+		}
+	}
+
+	// per-route middlewares are validated in boot-time.
+	config.RouteTable[len(config.RouteTable)-1].Middlewares = []kocha.Middleware{&kocha.SessionMiddleware{}}
+	_, err = kocha.New(config)
+	var actual interface{} = err
+	var expect interface{} = fmt.Errorf("kocha: session: because Store is nil, session cannot be used")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`New(config) with invalid route middleware => (_, %#v); want (_, %#v)`, actual, expect)
+	}
+}
+
+func TestApplication_ServeHTTP_withMethodNotAllowed(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name:       "options",
+		Path:       "/options",
+		Controller: &testOptionsCtrl{},
+	}, &kocha.Route{
+		Name:       "fallback",
+		Path:       "/fallback",
+		Controller: &testFallbackCtrl{},
+	})
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		method string
+		uri    string
+		status int
+		body   string
+		allow  string
+	}{
+		{"GET", "/", http.StatusOK, "This is layout\nThis is root\n\n", ""},
+		{"OPTIONS", "/", http.StatusOK, "", "GET, OPTIONS"},
+		{"POST", "/", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "GET, OPTIONS"},
+		{"HEAD", "/", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "GET, OPTIONS"},
+		{"PUT", "/", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "GET, OPTIONS"},
+		{"PATCH", "/", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "GET, OPTIONS"},
+		{"DELETE", "/", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "GET, OPTIONS"},
+		{"TRACE", "/", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "GET, OPTIONS"},
+		{"GET", "/post_test", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "POST, OPTIONS"},
+		{"GET", "/options", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "PUT, OPTIONS"},
+		{"HEAD", "/options", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "PUT, OPTIONS"},
+		{"POST", "/options", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", "PUT, OPTIONS"},
+		{"PUT", "/options", http.StatusOK, "PUT", ""},
+		{"OPTIONS", "/options", http.StatusOK, "OPTIONS", ""},
+		{"GET", "/fallback?fallback=1", http.StatusMethodNotAllowed, "This is layout\n405 method not allowed\n\n", ""},
+		{"GET", "/fallback", http.StatusOK, "GET", ""},
+		{"OPTIONS", "/fallback", http.StatusOK, "", "GET, OPTIONS"},
+		{"OPTIONS", "/missing", http.StatusNotFound, "This is layout\n404 template not found\n\n", ""},
+	} {
+		req, err := http.NewRequest(v.method, v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v status => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+
+		actual = w.Header().Get("Allow")
+		expect = v.allow
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v Allow => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+	}
+}
+
+func TestApplication_ServeHTTP_withMount(t *testing.T) {
+	var called []string
+	sub, err := kocha.New(&kocha.Config{
+		AppPath: "testdata",
+		AppName: "admin",
+		RouteTable: kocha.RouteTable{
+			{Name: "root", Path: "/", Controller: &testRouteNameCtrl{}},
+			{Name: "user", Path: "/user/:id", Controller: &testRouteNameCtrl{}},
+		},
+		Middlewares: []kocha.Middleware{
+			&TestMiddleware{t: t, id: "Admin", called: &called},
+			&kocha.DispatchMiddleware{},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := kocha.NewTestApp().Config
+	config.Middlewares = []kocha.Middleware{
+		&TestMiddleware{t: t, id: "Global", called: &called},
+		&kocha.DispatchMiddleware{},
+	}
+	// a failed New doesn't leave the application mounted.
+	for _, v := range []struct {
+		mounts []*kocha.Mount
+		expect error
+	}{
+		{[]*kocha.Mount{{Prefix: "/admin/", App: sub}, {Prefix: "admin2", App: sub}}, fmt.Errorf("kocha: mount: Prefix must start with `/', but \"admin2\"")},
+		{[]*kocha.Mount{{Prefix: "/admin/", App: sub}, {Prefix: "/admin2", App: sub}}, fmt.Errorf("kocha: mount: admin has already been mounted")},
+	} {
+		config.Mounts = v.mounts
+		_, err := kocha.New(config)
+		if !reflect.DeepEqual(err, v.expect) {
+			t.Errorf(`New(config) with %#v => (_, %#v); want (_, %#v)`, v.mounts, err, v.expect)
+		}
+	}
+	config.Mounts = []*kocha.Mount{{Prefix: "/admin/", App: sub}}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri    string
+		status int
+		body   string
+		called []string
+	}{
+		{"/admin", http.StatusOK, "root", []string{"beforeAdmin", "afterAdmin"}},
+		{"/admin/", http.StatusOK, "root", []string{"beforeAdmin", "afterAdmin"}},
+		{"/admin/user/1", http.StatusOK, "user:1", []string{"beforeAdmin", "afterAdmin"}},
+		{"/administrator", http.StatusNotFound, "This is layout\n404 template not found\n\n", []string{"beforeGlobal", "afterGlobal"}},
+		{"/user/1", http.StatusOK, "This is layout\nThis is user 1\n\n", []string{"beforeGlobal", "afterGlobal"}},
+	} {
+		called = nil
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v status => %#v; want %#v`, v.uri, actual, expect)
+		}
+
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v => %#v; want %#v`, v.uri, actual, expect)
+		}
+
+		actual = called
+		expect = v.called
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with middlewares calls => %#v; want %#v`, v.uri, actual, expect)
+		}
+	}
+
+	for _, v := range []struct {
+		router *kocha.Router
+		name   string
+		args   []interface{}
+		expect string
+	}{
+		{app.Router, "admin:user", []interface{}{1}, "/admin/user/1"},
+		{app.Router, "admin:root", nil, "/admin/"},
+		{sub.Router, "user", []interface{}{2}, "/admin/user/2"},
+		{sub.Router, "appname:user", []interface{}{3}, "/user/3"},
+	} {
+		actual, err := v.router.Reverse(v.name, v.args...)
+		if err != nil {
+			t.Errorf(`Reverse(%#v, %#v) => _, %#v; want nil`, v.name, v.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf(`Reverse(%#v, %#v) => %#v; want %#v`, v.name, v.args, actual, v.expect)
+		}
+	}
+
+	// an application cannot be mounted twice.
+	config.Mounts = []*kocha.Mount{{Prefix: "/admin2", App: sub}}
+	_, err = kocha.New(config)
+	var actual interface{} = err
+	var expect interface{} = fmt.Errorf("kocha: mount: admin has already been mounted")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`New(config) with mounted app => (_, %#v); want (_, %#v)`, actual, expect)
+	}
+}
+
+func TestApplication_ServeHTTP_withHandler(t *testing.T) {
+	var called []string
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name: "handler",
+		Path: "/handler/*path",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+		}),
+	})
+	config.Middlewares = []kocha.Middleware{
+		&TestMiddleware{t: t, id: "Global", called: &called},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		method string
+		uri    string
+		status int
+		body   string
+	}{
+		{"GET", "/handler/", http.StatusAccepted, "GET /handler/"},
+		{"GET", "/handler/a/b", http.StatusAccepted, "GET /handler/a/b"},
+		{"DELETE", "/handler/a", http.StatusAccepted, "DELETE /handler/a"},
+	} {
+		called = nil
+		req, err := http.NewRequest(v.method, v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v status => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+
+		actual = called
+		expect = []string{"beforeGlobal", "afterGlobal"}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v with middlewares calls => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+	}
+
+	config.RouteTable[len(config.RouteTable)-1].Controller = &testRouteNameCtrl{}
+	_, err = kocha.New(config)
+	var actual interface{} = err
+	var expect interface{} = fmt.Errorf("kocha: route handler: Controller and Handler cannot be specified at the same time")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`New(config) with Controller and Handler => (_, %#v); want (_, %#v)`, actual, expect)
+	}
+}
+
+func TestApplication_ServeHTTP_withRedirectAndRewrite(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable,
+		&kocha.Route{Name: "old_user", Path: "/members/:id", Controller: &kocha.RedirectController{URL: "/user/:id"}},
+		&kocha.Route{Name: "moved", Path: "/moved/*path", Controller: &kocha.RedirectController{URL: "https://example.com/*path", StatusCode: http.StatusTemporaryRedirect}},
+		&kocha.Route{Name: "alias_user", Path: "/alias/:id", Controller: &kocha.RewriteController{Path: "/named/:id"}},
+		&kocha.Route{Name: "named", Path: "/named/:id", Controller: &testRouteNameCtrl{}},
+		&kocha.Route{Name: "loop", Path: "/loop", Controller: &kocha.RewriteController{Path: "/loop"}},
+	)
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		method   string
+		uri      string
+		status   int
+		location string
+		body     string
+	}{
+		{"GET", "/members/1", http.StatusMovedPermanently, "/user/1", ""},
+		{"GET", "/members/1?page=2", http.StatusMovedPermanently, "/user/1?page=2", ""},
+		{"POST", "/moved/a/b%20c", http.StatusTemporaryRedirect, "https://example.com/a/b%20c", ""},
+		{"GET", "/alias/2", http.StatusOK, "", "named:2"},
+		{"GET", "/loop", http.StatusInternalServerError, "", "Internal Server Error\n"},
+	} {
+		req, err := http.NewRequest(v.method, v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v status => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+
+		actual = w.Header().Get("Location")
+		expect = v.location
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v Location => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+
+		if v.body == "" {
+			continue
+		}
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%s %#v => %#v; want %#v`, v.method, v.uri, actual, expect)
+		}
+	}
+
+	config.RouteTable[len(config.RouteTable)-1].Controller = &kocha.RedirectController{URL: "/", StatusCode: http.StatusOK}
+	_, err = kocha.New(config)
+	var actual interface{} = err
+	var expect interface{} = fmt.Errorf("kocha: redirect: invalid status code: 200")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`New(config) with invalid redirect => (_, %#v); want (_, %#v)`, actual, expect)
+	}
+}
+
+type testRouteNameCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testRouteNameCtrl) GET(c *kocha.Context) error {
+	if id := c.Params.Get("id"); id != "" {
+		return c.RenderText(c.Name + ":" + id)
+	}
+	return c.RenderText(c.Name)
+}
+
 type TestMiddleware struct {
 	t      *testing.T
 	id     string
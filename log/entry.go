@@ -169,11 +169,22 @@ func (l *entryLogger) Output(level Level, message string) {
 	}
 }
 
+// With returns a new Logger that has both the fields of l and fields.
+// The fields of l are overridden by fields if the keys are duplicated.
 func (l *entryLogger) With(fields Fields) Logger {
 	l.mu.Lock()
-	l.entry.Fields = fields
+	merged := make(Fields, len(l.entry.Fields)+len(fields))
+	for k, v := range l.entry.Fields {
+		merged[k] = v
+	}
 	l.mu.Unlock()
-	return l
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &entryLogger{
+		logger: l.logger,
+		entry:  &Entry{Fields: merged},
+	}
 }
 
 func (l *entryLogger) Level() Level {
@@ -1323,6 +1323,28 @@ func TestLogger_With_Output(t *testing.T) {
 	}
 }
 
+func TestLogger_With_With(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	var buf bytes.Buffer
+	logger := log.New(&buf, &log.LTSVFormatter{}, log.INFO).With(log.Fields{
+		"first":  1,
+		"second": "two",
+	})
+	logger.With(log.Fields{
+		"second": 2,
+		"third":  "three",
+	}).Info("child")
+	logger.Info("parent")
+	actual := buf.String()
+	expected := "level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:child\tfirst:1\tsecond:2\tthird:three\n" +
+		"level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:parent\tfirst:1\tsecond:two\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`logger.With(...).With(...).Info("child"); logger.With(...).Info("parent") prints %#v; want %#v`, actual, expected)
+	}
+}
+
 func TestLogger_Level(t *testing.T) {
 	for _, level := range []log.Level{
 		log.NONE,
@@ -2,9 +2,18 @@ package kocha
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/woremacx/kocha/log"
@@ -31,22 +40,22 @@ func (m *PanicRecoverMiddleware) Process(app *Application, c *Context, next func
 	defer func() {
 		defer func() {
 			if perr := recover(); perr != nil {
-				app.logStackAndError(perr)
+				logStackAndError(requestLogger(app, c), perr)
 				err = fmt.Errorf("%v", perr)
 			}
 		}()
 		if err != nil {
-			app.Logger.Error(err)
+			requestLogger(app, c).Error(err)
 			goto ERROR
 		} else if perr := recover(); perr != nil {
-			app.logStackAndError(perr)
+			logStackAndError(requestLogger(app, c), perr)
 			goto ERROR
 		}
 		return
 	ERROR:
 		c.Response.reset()
 		if err = internalServerErrorController.GET(c); err != nil {
-			app.logStackAndError(err)
+			logStackAndError(requestLogger(app, c), err)
 		}
 	}()
 	return next()
@@ -120,9 +129,9 @@ func (m *SessionMiddleware) before(app *Application, c *Context) (err error) {
 		case nil:
 			// do nothing.
 		case ErrSession:
-			app.Logger.Info(err)
+			requestLogger(app, c).Info(err)
 		default:
-			app.Logger.Error(err)
+			requestLogger(app, c).Error(err)
 		}
 		if c.Session == nil {
 			c.Session = make(Session)
@@ -206,7 +215,7 @@ func (m *FlashMiddleware) Process(app *Application, c *Context, next func() erro
 
 func (m *FlashMiddleware) before(app *Application, c *Context) error {
 	if c.Session == nil {
-		app.Logger.Error("kocha: FlashMiddleware hasn't been added after SessionMiddleware; it cannot be used")
+		requestLogger(app, c).Error("kocha: FlashMiddleware hasn't been added after SessionMiddleware; it cannot be used")
 		return nil
 	}
 	c.Flash = Flash{}
@@ -241,12 +250,921 @@ func (m *FlashMiddleware) after(app *Application, c *Context) error {
 	return nil
 }
 
+// CSRFMiddleware is a middleware to protect from the Cross-Site Request Forgery.
+//
+// It keeps a token per session in Context.Session, and checks that the token
+// is sent by the form field or the header on the unsafe HTTP methods such as
+// POST. If the token is invalid, it renders the HTTP 403 Forbidden.
+// The token can be embedded into the templates by "csrf_token" and
+// "csrf_field" template functions. e.g. {{csrf_field .}}
+//
+// CSRFMiddleware must be placed after SessionMiddleware, otherwise New returns
+// an error.
+type CSRFMiddleware struct {
+	// Name of the form field that holds the token.
+	// Default is "_csrf_token".
+	FieldName string
+
+	// Name of the header that holds the token.
+	// Default is "X-CSRF-Token".
+	HeaderName string
+
+	// Key of the session that holds the token.
+	// Default is "_kocha._csrf_token".
+	SessionKey string
+}
+
+func (m *CSRFMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if c.Session == nil {
+		return fmt.Errorf("kocha: csrf: CSRFMiddleware hasn't been added after SessionMiddleware")
+	}
+	token := c.Session.Get(m.SessionKey)
+	if token == "" {
+		token = base64.RawURLEncoding.EncodeToString(util.GenerateRandomKey(32))
+		c.Session.Set(m.SessionKey, token)
+	}
+	c.csrfToken, c.csrfFieldName = token, m.FieldName
+	switch c.Request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return next()
+	}
+	sent := c.Request.Header.Get(m.HeaderName)
+	if sent == "" {
+		sent = c.Request.FormValue(m.FieldName)
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		return c.RenderError(http.StatusForbidden, nil, nil)
+	}
+	return next()
+}
+
+// Validate validates configuration of the CSRF protection.
+func (m *CSRFMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: csrf: middleware is nil")
+	}
+	if m.FieldName == "" {
+		m.FieldName = "_csrf_token"
+	}
+	if m.HeaderName == "" {
+		m.HeaderName = "X-CSRF-Token"
+	}
+	if m.SessionKey == "" {
+		m.SessionKey = "_kocha._csrf_token"
+	}
+	return nil
+}
+
+// CORSMiddleware is a middleware to process the Cross-Origin Resource Sharing.
+//
+// It answers the preflight requests by itself without processing the
+// following middlewares. So it should be placed before DispatchMiddleware.
+// The preflight request for the method or the headers that aren't allowed is
+// refused by the HTTP 403 Forbidden.
+type CORSMiddleware struct {
+	// Origins that are allowed to access the resources.
+	// An origin can contain a wildcard such as "https://*.example.com".
+	// "*" allows any origin.
+	AllowOrigins []string
+
+	// HTTP methods that are allowed in the actual requests.
+	// The preflight request for the other methods is refused.
+	// Default is GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowMethods []string
+
+	// HTTP headers that are allowed in the actual requests.
+	// The preflight request for the other headers is refused.
+	// Default is Accept, Accept-Language, Content-Language and Content-Type.
+	AllowHeaders []string
+
+	// HTTP headers that are exposed to the client.
+	ExposeHeaders []string
+
+	// Whether the client can send the credentials such as the cookies.
+	AllowCredentials bool
+
+	// How long the results of the preflight request can be cached.
+	// 0 is for not to send the Access-Control-Max-Age header.
+	MaxAge time.Duration
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	methods       map[string]bool
+	headers       map[string]bool
+}
+
+func (m *CORSMiddleware) Process(app *Application, c *Context, next func() error) error {
+	origin := c.Request.Header.Get("Origin")
+	if origin == "" {
+		return next()
+	}
+	header := c.Response.Header()
+	header.Add("Vary", "Origin")
+	if !m.allowOrigin(origin) {
+		return next()
+	}
+	method := c.Request.Header.Get("Access-Control-Request-Method")
+	if c.Request.Method != "OPTIONS" || method == "" {
+		m.setAllowOrigin(header, origin)
+		if m.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", m.exposeHeaders)
+		}
+		return next()
+	}
+	// preflight request.
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	if !m.methods[strings.ToUpper(method)] || !m.allowRequestHeaders(c.Request.Header.Get("Access-Control-Request-Headers")) {
+		c.Response.StatusCode = http.StatusForbidden
+		c.Response.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+	m.setAllowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", m.allowMethods)
+	header.Set("Access-Control-Allow-Headers", m.allowHeaders)
+	if m.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(m.MaxAge/time.Second), 10))
+	}
+	c.Response.StatusCode = http.StatusNoContent
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Validate validates configuration of the CORS.
+func (m *CORSMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: cors: middleware is nil")
+	}
+	if len(m.AllowOrigins) == 0 {
+		return fmt.Errorf("kocha: cors: AllowOrigins must be specified")
+	}
+	for _, origin := range m.AllowOrigins {
+		if origin == "*" && len(m.AllowOrigins) > 1 {
+			return fmt.Errorf("kocha: cors: `*' cannot be combined with the other origins")
+		}
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("kocha: cors: origin must contain at most one wildcard, but %q", origin)
+		}
+	}
+	if m.AllowCredentials && m.isAnyOrigin() {
+		return fmt.Errorf("kocha: cors: `*' cannot be used as AllowOrigins with AllowCredentials")
+	}
+	methods := m.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	m.methods = make(map[string]bool, len(methods))
+	for _, method := range methods {
+		m.methods[strings.ToUpper(method)] = true
+	}
+	headers := m.AllowHeaders
+	if len(headers) == 0 {
+		headers = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+	}
+	m.headers = make(map[string]bool, len(headers))
+	for _, h := range headers {
+		m.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	m.allowMethods = strings.ToUpper(strings.Join(methods, ", "))
+	m.allowHeaders = strings.Join(headers, ", ")
+	m.exposeHeaders = strings.Join(m.ExposeHeaders, ", ")
+	return nil
+}
+
+func (m *CORSMiddleware) setAllowOrigin(header http.Header, origin string) {
+	if m.isAnyOrigin() {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if m.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowRequestHeaders returns whether all of the headers in the value of
+// Access-Control-Request-Headers are allowed.
+func (m *CORSMiddleware) allowRequestHeaders(value string) bool {
+	for _, h := range strings.Split(value, ",") {
+		if h = strings.TrimSpace(h); h != "" && !m.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *CORSMiddleware) isAnyOrigin() bool {
+	return len(m.AllowOrigins) == 1 && m.AllowOrigins[0] == "*"
+}
+
+func (m *CORSMiddleware) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allow := range m.AllowOrigins {
+		allow = strings.ToLower(allow)
+		i := strings.IndexByte(allow, '*')
+		if i < 0 {
+			if origin == allow {
+				return true
+			}
+			continue
+		}
+		prefix, suffix := allow[:i], allow[i+1:]
+		if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultHSTSMaxAge is the default max age of the HTTP Strict Transport
+// Security for SecureHeadersMiddleware.
+const DefaultHSTSMaxAge = 180 * 24 * time.Hour
+
+// SecureHeadersMiddleware is a middleware to set the security related headers
+// to the response.
+//
+// If ContentSecurityPolicy is specified, a fresh nonce is generated for each
+// request and "{nonce}" in the policy is replaced with it. The nonce can be
+// embedded into the templates by "csp_nonce" template function.
+// e.g. <script nonce="{{csp_nonce .}}">
+type SecureHeadersMiddleware struct {
+	// Max age of the HTTP Strict Transport Security.
+	// Strict-Transport-Security header is sent only on the SSL connections.
+	// Default is DefaultHSTSMaxAge. If negative, the header isn't sent.
+	HSTSMaxAge time.Duration
+
+	// Whether to add "includeSubDomains" directive to the HSTS.
+	HSTSIncludeSubDomains bool
+
+	// Whether to add "preload" directive to the HSTS.
+	HSTSPreload bool
+
+	// Value of X-Frame-Options header. "DENY" or "SAMEORIGIN".
+	// Default is "SAMEORIGIN". If "-", the header isn't sent.
+	FrameOptions string
+
+	// Value of Referrer-Policy header.
+	// Default is "strict-origin-when-cross-origin". If "-", the header isn't sent.
+	ReferrerPolicy string
+
+	// Whether to allow the browsers to sniff the content type.
+	// If false, "X-Content-Type-Options: nosniff" is sent.
+	AllowContentTypeSniffing bool
+
+	// Value of Content-Security-Policy header.
+	// e.g. "default-src 'self'; script-src 'self' 'nonce-{nonce}'"
+	// If empty, the header isn't sent.
+	ContentSecurityPolicy string
+
+	// Whether to send the policy as Content-Security-Policy-Report-Only
+	// header instead of Content-Security-Policy.
+	CSPReportOnly bool
+
+	hsts string
+}
+
+func (m *SecureHeadersMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if m.ContentSecurityPolicy != "" {
+		c.cspNonce = base64.RawURLEncoding.EncodeToString(util.GenerateRandomKey(16))
+	}
+	m.setHeaders(c)
+	defer func() {
+		// the response might have been reset by the following middlewares.
+		m.setHeaders(c)
+	}()
+	return next()
+}
+
+// Validate validates configuration of the security headers.
+func (m *SecureHeadersMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: secureheaders: middleware is nil")
+	}
+	if m.HSTSMaxAge == 0 {
+		m.HSTSMaxAge = DefaultHSTSMaxAge
+	}
+	if m.HSTSMaxAge > 0 {
+		m.hsts = "max-age=" + strconv.FormatInt(int64(m.HSTSMaxAge/time.Second), 10)
+		if m.HSTSIncludeSubDomains {
+			m.hsts += "; includeSubDomains"
+		}
+		if m.HSTSPreload {
+			m.hsts += "; preload"
+		}
+	}
+	switch m.FrameOptions = strings.ToUpper(m.FrameOptions); m.FrameOptions {
+	case "":
+		m.FrameOptions = "SAMEORIGIN"
+	case "DENY", "SAMEORIGIN", "-":
+	default:
+		return fmt.Errorf("kocha: secureheaders: FrameOptions must be DENY or SAMEORIGIN, but %q", m.FrameOptions)
+	}
+	if m.ReferrerPolicy == "" {
+		m.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if strings.ContainsAny(m.ReferrerPolicy+m.ContentSecurityPolicy, "\r\n") {
+		return fmt.Errorf("kocha: secureheaders: header value must not contain a newline")
+	}
+	return nil
+}
+
+func (m *SecureHeadersMiddleware) setHeaders(c *Context) {
+	header := c.Response.Header()
+	if m.hsts != "" && c.Request.IsSSL() {
+		header.Set("Strict-Transport-Security", m.hsts)
+	}
+	if !m.AllowContentTypeSniffing {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+	if m.FrameOptions != "-" {
+		header.Set("X-Frame-Options", m.FrameOptions)
+	}
+	if m.ReferrerPolicy != "-" {
+		header.Set("Referrer-Policy", m.ReferrerPolicy)
+	}
+	if m.ContentSecurityPolicy != "" {
+		name := "Content-Security-Policy"
+		if m.CSPReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		header.Set(name, strings.Replace(m.ContentSecurityPolicy, "{nonce}", c.cspNonce, -1))
+	}
+}
+
+// DefaultCompressContentTypes is the default content types to compress by
+// CompressMiddleware.
+var DefaultCompressContentTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// DefaultCompressMinSize is the default minimum size of the response body to
+// compress by CompressMiddleware.
+const DefaultCompressMinSize = 1024
+
+// CompressMiddleware is a middleware to compress the response body by gzip
+// or deflate that is negotiated by Accept-Encoding header.
+//
+// The response that is already encoded, or the response that its body is
+// smaller than MinSize, isn't compressed.
+type CompressMiddleware struct {
+	// Content types to compress.
+	// A content type can be a wildcard subtype such as "text/*".
+	// Default is DefaultCompressContentTypes.
+	ContentTypes []string
+
+	// Minimum size of the response body to compress in bytes.
+	// Default is DefaultCompressMinSize. If negative, the response body of
+	// any size is compressed except the empty one.
+	MinSize int
+
+	// Compression level. e.g. gzip.BestSpeed
+	// Default is gzip.DefaultCompression. Since 0 is regarded as the default,
+	// use NoCompression instead of gzip.NoCompression.
+	Level int
+
+	// Whether to encode the response body without the compression, that is,
+	// gzip.NoCompression. Level must not be specified at the same time.
+	NoCompression bool
+}
+
+func (m *CompressMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if err := next(); err != nil {
+		return err
+	}
+	if c.Response.IsStreaming() {
+		return nil
+	}
+	header := c.Response.Header()
+	if header.Get("Content-Encoding") != "" || !m.isCompressible(header.Get("Content-Type")) {
+		return nil
+	}
+	header.Add("Vary", "Accept-Encoding")
+	body := c.Response.resp.Body
+	if body == nil || body.Len() == 0 || body.Len() < m.MinSize {
+		return nil
+	}
+	encoding := m.negotiate(c.Request.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch encoding {
+	case "gzip":
+		w, err = gzip.NewWriterLevel(&buf, m.Level)
+	case "deflate":
+		w, err = flate.NewWriter(&buf, m.Level)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := body.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	header.Set("Content-Encoding", encoding)
+	header.Del("Content-Length")
+	c.Response.resp.Body = &buf
+	return nil
+}
+
+// Validate validates configuration of the compression.
+func (m *CompressMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: compress: middleware is nil")
+	}
+	if m.ContentTypes == nil {
+		m.ContentTypes = DefaultCompressContentTypes
+	}
+	if m.MinSize == 0 {
+		m.MinSize = DefaultCompressMinSize
+	}
+	if m.NoCompression {
+		if m.Level != gzip.NoCompression {
+			return fmt.Errorf("kocha: compress: Level and NoCompression cannot be specified at the same time")
+		}
+	} else if m.Level == 0 {
+		m.Level = gzip.DefaultCompression
+	}
+	if m.Level < gzip.HuffmanOnly || m.Level > gzip.BestCompression {
+		return fmt.Errorf("kocha: compress: invalid compression level: %v", m.Level)
+	}
+	return nil
+}
+
+func (m *CompressMiddleware) isCompressible(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if contentType == "" {
+		return false
+	}
+	for _, t := range m.ContentTypes {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(contentType, t[:len(t)-1]) {
+				return true
+			}
+			continue
+		}
+		if contentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the encoding that is acceptable by the client.
+// If no encoding is acceptable, it returns "".
+func (m *CompressMiddleware) negotiate(acceptEncoding string) string {
+	specs := parseAccept(acceptEncoding)
+	rejected := make(map[string]bool)
+	for _, spec := range specs {
+		if spec.q <= 0 {
+			rejected[spec.value] = true
+		}
+	}
+	for _, spec := range specs {
+		if spec.q <= 0 {
+			continue
+		}
+		switch spec.value {
+		case "gzip", "deflate":
+			return spec.value
+		case "*":
+			for _, encoding := range []string{"gzip", "deflate"} {
+				if !rejected[encoding] {
+					return encoding
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// ETagMiddleware is a middleware to process the conditional GET.
+//
+// It adds the ETag header that is generated from the hash of the response
+// body if the ETag header hasn't been set, and then, it turns the response
+// into the HTTP 304 Not Modified without body if the response matches
+// If-None-Match or If-Modified-Since header of the request.
+// It should be placed before CompressMiddleware in order to generate the
+// ETag from the encoded response body.
+type ETagMiddleware struct {
+	// Whether to generate the weak ETag such as W/"...".
+	Weak bool
+}
+
+func (m *ETagMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if err := next(); err != nil {
+		return err
+	}
+	if method := c.Request.Method; method != "GET" && method != "HEAD" {
+		return nil
+	}
+	if c.Response.IsStreaming() || c.Response.resp.Code != http.StatusOK {
+		return nil
+	}
+	header := c.Response.Header()
+	etag := header.Get("ETag")
+	if etag == "" && c.Response.resp.Body != nil {
+		sum := sha1.Sum(c.Response.resp.Body.Bytes())
+		etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		if m.Weak {
+			etag = "W/" + etag
+		}
+		header.Set("ETag", etag)
+	}
+	if !m.notModified(c.Request, etag, header.Get("Last-Modified")) {
+		return nil
+	}
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+		header.Del(key)
+	}
+	c.Response.resp.Body.Reset()
+	c.Response.resp.Code = http.StatusNotModified
+	c.Response.StatusCode = http.StatusNotModified
+	return nil
+}
+
+// notModified returns whether the response hasn't been modified since the
+// client has received.
+func (m *ETagMiddleware) notModified(req *Request, etag, lastModified string) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modtime, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modtime.After(since)
+}
+
+// ContentNegotiationMiddleware is a middleware to choose the format of the
+// response from the request.
+//
+// The format is taken from the following in order, and it's set to
+// Context.Format and Context.Response.ContentType. Then Context.Render
+// renders the template of the format such as "root.json".
+//
+// 1. The extension of the path such as "/users/1.json" if PathExtension is true.
+//
+// 2. The parameter of ParamName such as "?format=json" if ParamName is specified.
+//
+// 3. Accept header of the request with the q-values.
+//
+// If the format isn't acceptable, or the template of the chosen format
+// doesn't exist in Context.Render, it renders the HTTP 406 Not Acceptable.
+// The renderers of the fixed type such as Context.RenderJSON override the
+// negotiated Context.Response.ContentType with their own type.
+// It must be placed before DispatchMiddleware.
+type ContentNegotiationMiddleware struct {
+	// Formats that the application can respond such as "html" and "json".
+	// The formats must be registered in MimeTypeFormats. The first one is
+	// used if the client accepts any format.
+	// Default is []string{"html"}.
+	Formats []string
+
+	// Whether to take the format from the extension of the path.
+	// The extension is removed from the path before the routing if it's one
+	// of Formats.
+	PathExtension bool
+
+	// Name of the parameter in the query string that specifies the format.
+	// If empty, the parameter isn't used.
+	ParamName string
+
+	mimeTypes []string
+}
+
+func (m *ContentNegotiationMiddleware) Process(app *Application, c *Context, next func() error) error {
+	format := ""
+	if m.PathExtension {
+		path := c.Request.URL.Path
+		if i := strings.LastIndexByte(path, '.'); i > strings.LastIndexByte(path, '/') {
+			if ext := strings.ToLower(path[i+1:]); m.indexOf(ext) >= 0 {
+				format = ext
+				c.Request.URL.Path, c.Request.URL.RawPath = path[:i], ""
+			}
+		}
+	}
+	if format == "" && m.ParamName != "" {
+		if format = strings.ToLower(c.Request.URL.Query().Get(m.ParamName)); format != "" && m.indexOf(format) < 0 {
+			return c.RenderError(http.StatusNotAcceptable, nil, nil)
+		}
+	}
+	if format == "" {
+		c.Response.Header().Add("Vary", "Accept")
+		if format = m.negotiate(c.Request.Header.Get("Accept")); format == "" {
+			return c.RenderError(http.StatusNotAcceptable, nil, nil)
+		}
+	}
+	c.Format, c.Response.ContentType = format, m.mimeTypes[m.indexOf(format)]
+	c.negotiated = c.Response.ContentType
+	return next()
+}
+
+// Validate validates configuration of the content negotiation.
+func (m *ContentNegotiationMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: negotiation: middleware is nil")
+	}
+	if len(m.Formats) == 0 {
+		m.Formats = []string{"html"}
+	}
+	m.mimeTypes = make([]string, len(m.Formats))
+	for i, format := range m.Formats {
+		m.Formats[i] = strings.ToLower(format)
+		for mimeType, f := range MimeTypeFormats {
+			if f == m.Formats[i] {
+				m.mimeTypes[i] = mimeType
+				break
+			}
+		}
+		if m.mimeTypes[i] == "" {
+			return fmt.Errorf("kocha: negotiation: format %q isn't registered in MimeTypeFormats", format)
+		}
+	}
+	return nil
+}
+
+func (m *ContentNegotiationMiddleware) indexOf(format string) int {
+	for i, f := range m.Formats {
+		if f == format {
+			return i
+		}
+	}
+	return -1
+}
+
+// negotiate returns the format that is the most acceptable in the Accept
+// header. If the header is empty, it returns the first format.
+func (m *ContentNegotiationMiddleware) negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return m.Formats[0]
+	}
+	specs := parseAccept(accept)
+	rejected := make(map[string]bool)
+	for _, spec := range specs {
+		if spec.q <= 0 {
+			rejected[spec.value] = true
+		}
+	}
+	for _, spec := range specs {
+		if spec.q <= 0 {
+			continue
+		}
+		for i, mimeType := range m.mimeTypes {
+			if rejected[mimeType] {
+				continue
+			}
+			if spec.value == "*/*" || spec.value == mimeType ||
+				strings.HasSuffix(spec.value, "/*") && strings.HasPrefix(mimeType, spec.value[:len(spec.value)-1]) {
+				return m.Formats[i]
+			}
+		}
+	}
+	return ""
+}
+
+// RateLimitMiddleware is a middleware to limit the rate of the requests per
+// client.
+//
+// The client is identified by the result of KeyFunc if it isn't nil, the
+// value of the session by SessionKey if it isn't empty, or
+// Request.RemoteAddr. If the client exceeds the limit, it renders the HTTP 429
+// Too Many Requests with the Retry-After header.
+type RateLimitMiddleware struct {
+	// Number of requests that are allowed in Period.
+	Limit int
+
+	// Period of the limit.
+	Period time.Duration
+
+	// Implementation of the rate limit store.
+	// Default is MemoryRateLimitStore.
+	Store RateLimitStore
+
+	// Function that returns the key to identify the client.
+	KeyFunc func(c *Context) string
+
+	// Key of the session that holds the value to identify the client.
+	// SessionMiddleware must be placed before RateLimitMiddleware to use it.
+	SessionKey string
+
+	// Whether to limit per route.
+	// If true, the limit is applied to each route name separately.
+	PerRoute bool
+}
+
+func (m *RateLimitMiddleware) Process(app *Application, c *Context, next func() error) error {
+	key := m.key(c)
+	if m.PerRoute {
+		name := c.Name
+		if name == "" {
+			if m := app.lookup(c); m.found && m.route != nil {
+				name = m.route.Name
+			}
+		}
+		key = name + ":" + key
+	}
+	result, err := m.Store.Take(key, m.Limit, m.Period)
+	if err != nil {
+		return err
+	}
+	header := c.Response.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(m.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+		return c.RenderError(http.StatusTooManyRequests, nil, nil)
+	}
+	return next()
+}
+
+// Validate validates configuration of the rate limit.
+func (m *RateLimitMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: ratelimit: middleware is nil")
+	}
+	if m.Limit < 1 {
+		return fmt.Errorf("kocha: ratelimit: Limit must be greater than 0")
+	}
+	if m.Period <= 0 {
+		return fmt.Errorf("kocha: ratelimit: Period must be greater than 0")
+	}
+	if m.Store == nil {
+		m.Store = NewMemoryRateLimitStore()
+	}
+	if v, ok := m.Store.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+func (m *RateLimitMiddleware) key(c *Context) string {
+	if m.KeyFunc != nil {
+		return m.KeyFunc(c)
+	}
+	if m.SessionKey != "" && c.Session != nil {
+		if key := c.Session.Get(m.SessionKey); key != "" {
+			return key
+		}
+	}
+	return c.Request.RemoteAddr
+}
+
+// ceilSeconds returns d in seconds that is rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// RequestIDMiddleware is a middleware to identify the request by the ID.
+//
+// It reads the request ID from the header of the request, or generates a new
+// one if it doesn't exist or is invalid. Then it adds the ID to the header of
+// the response, and sets the request-scoped logger that has the "request_id"
+// field to Context.Logger. It should be placed at first of the middlewares
+// in order to log with the ID in all the following middlewares.
+type RequestIDMiddleware struct {
+	// Name of the header.
+	// Default is "X-Request-ID".
+	HeaderName string
+
+	// Function that generates a new request ID.
+	// Default generates a random hex string.
+	Generator func() string
+}
+
+func (m *RequestIDMiddleware) Process(app *Application, c *Context, next func() error) error {
+	id := c.Request.Header.Get(m.HeaderName)
+	if !isValidRequestID(id) {
+		id = m.Generator()
+	}
+	c.Logger = app.Logger.With(log.Fields{
+		"request_id": id,
+	})
+	c.Response.Header().Set(m.HeaderName, id)
+	defer func() {
+		// the response might have been reset by the following middlewares.
+		c.Response.Header().Set(m.HeaderName, id)
+	}()
+	return next()
+}
+
+// Validate validates configuration of the request ID.
+func (m *RequestIDMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: requestid: middleware is nil")
+	}
+	if m.HeaderName == "" {
+		m.HeaderName = "X-Request-ID"
+	}
+	if m.Generator == nil {
+		m.Generator = generateRequestID
+	}
+	return nil
+}
+
+func generateRequestID() string {
+	return hex.EncodeToString(util.GenerateRandomKey(16))
+}
+
+// isValidRequestID returns whether the id that is given by the client can be
+// used as the request ID.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// TimeoutMiddleware is a middleware to render the error when the request has
+// been cancelled by the timeout of Config.Timeout or Route.Timeout, or by the
+// shutdown of the server.
+//
+// TimeoutMiddleware checks the deadline only after the handler returns, and
+// never preempts the handler. The handler should watch Context.Context() and
+// return as soon as it's done; a handler that ignores it runs to completion.
+// After that, the response is replaced with the error page of StatusCode on
+// the timeout, or of HTTP 503 Service Unavailable on the cancellation.
+type TimeoutMiddleware struct {
+	// HTTP status code that is rendered on the timeout.
+	// Default is http.StatusGatewayTimeout.
+	StatusCode int
+}
+
+func (m *TimeoutMiddleware) Process(app *Application, c *Context, next func() error) error {
+	err := next()
+	if c.Response.IsStreaming() {
+		// the response has already been sent.
+		return err
+	}
+	var statusCode int
+	switch c.Context().Err() {
+	case nil:
+		return err
+	case context.DeadlineExceeded:
+		statusCode = m.StatusCode
+	default:
+		statusCode = http.StatusServiceUnavailable
+	}
+	requestLogger(app, c).Warnf("kocha: timeout: %v %v: %v", c.Request.Method, c.Request.URL.Path, c.Context().Err())
+	c.Response.reset()
+	return c.RenderError(statusCode, nil, nil)
+}
+
+// Validate validates configuration of the timeout.
+func (m *TimeoutMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: timeout: middleware is nil")
+	}
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusGatewayTimeout
+	}
+	if m.StatusCode < 400 || m.StatusCode > 599 {
+		return fmt.Errorf("kocha: timeout: StatusCode must be an error status code, but %v", m.StatusCode)
+	}
+	return nil
+}
+
 // Request logging middleware.
 type RequestLoggingMiddleware struct{}
 
 func (m *RequestLoggingMiddleware) Process(app *Application, c *Context, next func() error) error {
 	defer func() {
-		app.Logger.With(log.Fields{
+		requestLogger(app, c).With(log.Fields{
 			"method":   c.Request.Method,
 			"uri":      c.Request.RequestURI,
 			"protocol": c.Request.Proto,
@@ -262,18 +1180,5 @@ type DispatchMiddleware struct{}
 
 // Process implements the Middleware interface.
 func (m *DispatchMiddleware) Process(app *Application, c *Context, next func() error) error {
-	name, handler, params, found := app.Router.dispatch(c.Request)
-	if !found {
-		handler = (&ErrorController{
-			StatusCode: http.StatusNotFound,
-		}).GET
-	}
-	c.Name = name
-	if c.Params == nil {
-		c.Params = c.newParams()
-	}
-	for _, param := range params {
-		c.Params.Add(param.Name, param.Value)
-	}
-	return handler(c)
+	return app.dispatch(c)
 }
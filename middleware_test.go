@@ -2,11 +2,18 @@ package kocha_test
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"html/template"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
@@ -563,6 +570,1115 @@ func TestSessionMiddleware_Validate(t *testing.T) {
 	}
 }
 
+func newCSRFTestApp(t *testing.T, m *kocha.CSRFMiddleware) *kocha.Application {
+	config := kocha.NewTestApp().Config
+	config.Middlewares = []kocha.Middleware{
+		&kocha.SessionMiddleware{Name: "test_session", Store: &NullSessionStore{}},
+		m,
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	m := &kocha.CSRFMiddleware{}
+	app := newCSRFTestApp(t, m)
+	for _, v := range []struct {
+		method string
+		header string
+		form   string
+		called bool
+		status int
+	}{
+		{"GET", "", "", true, http.StatusOK},
+		{"HEAD", "", "", true, http.StatusOK},
+		{"POST", "", "", false, http.StatusForbidden},
+		{"POST", "invalid", "", false, http.StatusForbidden},
+		{"POST", "", "_csrf_token=invalid", false, http.StatusForbidden},
+		{"POST", "test_token", "", true, http.StatusOK},
+		{"PUT", "", "_csrf_token=test_token", true, http.StatusOK},
+		{"DELETE", "test_token", "", true, http.StatusOK},
+	} {
+		r, err := http.NewRequest(v.method, "/", strings.NewReader(v.form))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if v.header != "" {
+			r.Header.Set("X-CSRF-Token", v.header)
+		}
+		w := httptest.NewRecorder()
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK},
+			Session:  kocha.Session{"_kocha._csrf_token": "test_token"},
+			App:      app,
+		}
+		called := false
+		if err := m.Process(app, c, func() error {
+			called = true
+			return nil
+		}); err != nil {
+			t.Error(err)
+			continue
+		}
+		var actual interface{} = called
+		var expect interface{} = v.called
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CSRFMiddleware.Process(app, c, func) with %s %#v %#v; called => %#v; want %#v`, v.method, v.header, v.form, actual, expect)
+		}
+		actual = c.Response.StatusCode
+		expect = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CSRFMiddleware.Process(app, c, func) with %s %#v %#v; status => %#v; want %#v`, v.method, v.header, v.form, actual, expect)
+		}
+	}
+
+	// a new token is generated if the session doesn't have it.
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &kocha.Context{
+		Request:  &kocha.Request{Request: r},
+		Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+		Session:  kocha.Session{},
+		App:      app,
+	}
+	if err := m.Process(app, c, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if token := c.Session.Get("_kocha._csrf_token"); len(token) < 32 {
+		t.Errorf(`CSRFMiddleware.Process(app, c, func); c.Session.Get("_kocha._csrf_token") => %#v; want random token`, token)
+	}
+}
+
+func TestCSRFMiddleware_Validate(t *testing.T) {
+	var actual interface{} = (*kocha.CSRFMiddleware)(nil).Validate()
+	var expect interface{} = fmt.Errorf("kocha: csrf: middleware is nil")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.CSRFMiddleware.Validate() with nil => %#v; want %#v`, actual, expect)
+	}
+
+	session := &kocha.SessionMiddleware{Name: "test_session", Store: &NullSessionStore{}}
+	for _, v := range []struct {
+		middlewares []kocha.Middleware
+		route       []kocha.Middleware
+		expect      error
+	}{
+		{[]kocha.Middleware{session, &kocha.CSRFMiddleware{}, &kocha.DispatchMiddleware{}}, nil, nil},
+		{[]kocha.Middleware{&kocha.CSRFMiddleware{}, session, &kocha.DispatchMiddleware{}}, nil, fmt.Errorf("kocha: csrf: CSRFMiddleware must be placed after SessionMiddleware")},
+		{[]kocha.Middleware{&kocha.CSRFMiddleware{}, &kocha.DispatchMiddleware{}}, nil, fmt.Errorf("kocha: csrf: CSRFMiddleware must be placed after SessionMiddleware")},
+		{[]kocha.Middleware{session, &kocha.DispatchMiddleware{}}, []kocha.Middleware{&kocha.CSRFMiddleware{}}, nil},
+		{[]kocha.Middleware{&kocha.DispatchMiddleware{}}, []kocha.Middleware{&kocha.CSRFMiddleware{}}, fmt.Errorf("kocha: csrf: CSRFMiddleware must be placed after SessionMiddleware")},
+		{[]kocha.Middleware{&kocha.DispatchMiddleware{}}, []kocha.Middleware{session, &kocha.CSRFMiddleware{}}, nil},
+	} {
+		config := kocha.NewTestApp().Config
+		config.Middlewares = v.middlewares
+		config.RouteTable[0].Middlewares = v.route
+		_, err := kocha.New(config)
+		actual = err
+		expect = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.New(config) with middlewares %#v and route middlewares %#v => (_, %#v); want (_, %#v)`, v.middlewares, v.route, actual, expect)
+		}
+	}
+
+	// the order is validated by New, not by Validate.
+	m := &kocha.CSRFMiddleware{}
+	actual = m.Validate()
+	expect = nil
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.CSRFMiddleware.Validate() => %#v; want %#v`, actual, expect)
+	}
+
+	// an instance can be shared between the applications.
+	for _, v := range []struct {
+		middlewares []kocha.Middleware
+		expect      error
+	}{
+		{[]kocha.Middleware{session, m, &kocha.DispatchMiddleware{}}, nil},
+		{[]kocha.Middleware{m, &kocha.DispatchMiddleware{}}, fmt.Errorf("kocha: csrf: CSRFMiddleware must be placed after SessionMiddleware")},
+		{[]kocha.Middleware{session, m, &kocha.DispatchMiddleware{}}, nil},
+	} {
+		config := kocha.NewTestApp().Config
+		config.Middlewares = v.middlewares
+		_, err := kocha.New(config)
+		actual = err
+		expect = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.New(config) with shared CSRFMiddleware in %#v => (_, %#v); want (_, %#v)`, v.middlewares, actual, expect)
+		}
+	}
+
+	m = &kocha.CSRFMiddleware{}
+	newCSRFTestApp(t, m)
+	actual = []string{m.FieldName, m.HeaderName, m.SessionKey}
+	expect = []string{"_csrf_token", "X-CSRF-Token", "_kocha._csrf_token"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.CSRFMiddleware.Validate(); [FieldName, HeaderName, SessionKey] => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	app := kocha.NewTestApp()
+	m := &kocha.CORSMiddleware{
+		AllowOrigins:     []string{"https://example.com", "https://*.example.org"},
+		AllowHeaders:     []string{"Content-Type", "X-CSRF-Token"},
+		ExposeHeaders:    []string{"X-Total-Count"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		method         string
+		origin         string
+		requestMethod  string
+		requestHeaders string
+		called         bool
+		status         int
+		header         http.Header
+	}{
+		{"GET", "", "", "", true, http.StatusOK, http.Header{}},
+		{"GET", "https://evil.com", "", "", true, http.StatusOK, http.Header{
+			"Vary": {"Origin"},
+		}},
+		{"GET", "https://example.com", "", "", true, http.StatusOK, http.Header{
+			"Vary":                             {"Origin"},
+			"Access-Control-Allow-Origin":      {"https://example.com"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Expose-Headers":    {"X-Total-Count"},
+		}},
+		{"POST", "https://api.example.org", "", "", true, http.StatusOK, http.Header{
+			"Vary":                             {"Origin"},
+			"Access-Control-Allow-Origin":      {"https://api.example.org"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Expose-Headers":    {"X-Total-Count"},
+		}},
+		{"OPTIONS", "https://example.com", "PUT", "", false, http.StatusNoContent, http.Header{
+			"Vary":                             {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			"Access-Control-Allow-Origin":      {"https://example.com"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Allow-Methods":     {"GET, HEAD, POST, PUT, PATCH, DELETE"},
+			"Access-Control-Allow-Headers":     {"Content-Type, X-CSRF-Token"},
+			"Access-Control-Max-Age":           {"600"},
+		}},
+		{"OPTIONS", "https://example.com", "patch", "content-type, x-csrf-token", false, http.StatusNoContent, http.Header{
+			"Vary":                             {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			"Access-Control-Allow-Origin":      {"https://example.com"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Allow-Methods":     {"GET, HEAD, POST, PUT, PATCH, DELETE"},
+			"Access-Control-Allow-Headers":     {"Content-Type, X-CSRF-Token"},
+			"Access-Control-Max-Age":           {"600"},
+		}},
+		{"OPTIONS", "https://example.com", "TRACE", "", false, http.StatusForbidden, http.Header{
+			"Vary": {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		}},
+		{"OPTIONS", "https://example.com", "PUT", "Content-Type, X-Evil", false, http.StatusForbidden, http.Header{
+			"Vary": {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		}},
+		{"OPTIONS", "https://example.com", "", "", true, http.StatusOK, http.Header{
+			"Vary":                             {"Origin"},
+			"Access-Control-Allow-Origin":      {"https://example.com"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Expose-Headers":    {"X-Total-Count"},
+		}},
+	} {
+		r, err := http.NewRequest(v.method, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.origin != "" {
+			r.Header.Set("Origin", v.origin)
+		}
+		if v.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", v.requestMethod)
+		}
+		if v.requestHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", v.requestHeaders)
+		}
+		w := httptest.NewRecorder()
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK},
+		}
+		called := false
+		if err := m.Process(app, c, func() error {
+			called = true
+			return nil
+		}); err != nil {
+			t.Error(err)
+			continue
+		}
+		var actual interface{} = called
+		var expect interface{} = v.called
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CORSMiddleware.Process(app, c, func) with %s from %#v; called => %#v; want %#v`, v.method, v.origin, actual, expect)
+		}
+		actual = c.Response.StatusCode
+		expect = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CORSMiddleware.Process(app, c, func) with %s from %#v; status => %#v; want %#v`, v.method, v.origin, actual, expect)
+		}
+		actual = w.Header()
+		expect = v.header
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CORSMiddleware.Process(app, c, func) with %s from %#v; header => %#v; want %#v`, v.method, v.origin, actual, expect)
+		}
+	}
+}
+
+func TestCORSMiddleware_withDefaultAllowHeaders(t *testing.T) {
+	app := kocha.NewTestApp()
+	m := &kocha.CORSMiddleware{AllowOrigins: []string{"*"}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		requestHeaders string
+		status         int
+		allowHeaders   string
+	}{
+		{"Content-Type", http.StatusNoContent, "Accept, Accept-Language, Content-Language, Content-Type"},
+		{"Authorization", http.StatusForbidden, ""},
+	} {
+		r, err := http.NewRequest("OPTIONS", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Origin", "https://example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", v.requestHeaders)
+		w := httptest.NewRecorder()
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK},
+		}
+		if err := m.Process(app, c, func() error { return nil }); err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := []interface{}{c.Response.StatusCode, w.Header().Get("Access-Control-Allow-Headers")}
+		expect := []interface{}{v.status, v.allowHeaders}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CORSMiddleware.Process(app, c, func) with Access-Control-Request-Headers %#v => %#v; want %#v`, v.requestHeaders, actual, expect)
+		}
+	}
+}
+
+func TestCORSMiddleware_Validate(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.CORSMiddleware
+		expect interface{}
+	}{
+		{(*kocha.CORSMiddleware)(nil), fmt.Errorf("kocha: cors: middleware is nil")},
+		{&kocha.CORSMiddleware{}, fmt.Errorf("kocha: cors: AllowOrigins must be specified")},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"*", "https://example.com"}}, fmt.Errorf("kocha: cors: `*' cannot be combined with the other origins")},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"https://*.*.example.com"}}, fmt.Errorf("kocha: cors: origin must contain at most one wildcard, but \"https://*.*.example.com\"")},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"*"}, AllowCredentials: true}, fmt.Errorf("kocha: cors: `*' cannot be used as AllowOrigins with AllowCredentials")},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"*"}}, nil},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, nil},
+	} {
+		actual := v.m.Validate()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.CORSMiddleware.Validate() with %#v => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+}
+
+func TestSecureHeadersMiddleware(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.SecureHeadersMiddleware
+		ssl    bool
+		expect http.Header
+	}{
+		{&kocha.SecureHeadersMiddleware{}, false, http.Header{
+			"X-Content-Type-Options": {"nosniff"},
+			"X-Frame-Options":        {"SAMEORIGIN"},
+			"Referrer-Policy":        {"strict-origin-when-cross-origin"},
+		}},
+		{&kocha.SecureHeadersMiddleware{}, true, http.Header{
+			"Strict-Transport-Security": {"max-age=15552000"},
+			"X-Content-Type-Options":    {"nosniff"},
+			"X-Frame-Options":           {"SAMEORIGIN"},
+			"Referrer-Policy":           {"strict-origin-when-cross-origin"},
+		}},
+		{&kocha.SecureHeadersMiddleware{
+			HSTSMaxAge:               time.Hour,
+			HSTSIncludeSubDomains:    true,
+			HSTSPreload:              true,
+			FrameOptions:             "deny",
+			ReferrerPolicy:           "no-referrer",
+			AllowContentTypeSniffing: true,
+			ContentSecurityPolicy:    "default-src 'self'",
+		}, true, http.Header{
+			"Strict-Transport-Security": {"max-age=3600; includeSubDomains; preload"},
+			"X-Frame-Options":           {"DENY"},
+			"Referrer-Policy":           {"no-referrer"},
+			"Content-Security-Policy":   {"default-src 'self'"},
+		}},
+		{&kocha.SecureHeadersMiddleware{
+			HSTSMaxAge:            -1,
+			FrameOptions:          "-",
+			ReferrerPolicy:        "-",
+			ContentSecurityPolicy: "default-src 'self'",
+			CSPReportOnly:         true,
+		}, true, http.Header{
+			"X-Content-Type-Options":              {"nosniff"},
+			"Content-Security-Policy-Report-Only": {"default-src 'self'"},
+		}},
+	} {
+		if err := v.m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.ssl {
+			r.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK},
+		}
+		if err := v.m.Process(nil, c, func() error { return nil }); err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := c.Response.Header()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`SecureHeadersMiddleware(%#v) with SSL %v; header => %#v; want %#v`, v.m, v.ssl, actual, expect)
+		}
+	}
+}
+
+func TestSecureHeadersMiddleware_nonce(t *testing.T) {
+	m := &kocha.SecureHeadersMiddleware{
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+	}
+	config := kocha.NewTestApp().Config
+	config.Middlewares = []kocha.Middleware{m, &kocha.DispatchMiddleware{}}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(template.New("test").Funcs(template.FuncMap(app.Template.FuncMap)).Parse(`{{csp_nonce .}}`))
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: http.StatusOK},
+			App:      app,
+		}
+		var buf bytes.Buffer
+		if err := m.Process(app, c, func() error {
+			return tmpl.Execute(&buf, c)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		nonce := buf.String()
+		if nonce == "" {
+			t.Fatalf(`{{csp_nonce .}} => %#v; want non-empty string`, nonce)
+		}
+		actual := c.Response.Header().Get("Content-Security-Policy")
+		expect := fmt.Sprintf("script-src 'nonce-%s'", nonce)
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`SecureHeadersMiddleware; Content-Security-Policy => %#v; want %#v`, actual, expect)
+		}
+		nonces = append(nonces, nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Errorf(`SecureHeadersMiddleware; nonces of two requests => %#v; want different nonces`, nonces)
+	}
+}
+
+func TestSecureHeadersMiddleware_Validate(t *testing.T) {
+	var actual interface{} = (*kocha.SecureHeadersMiddleware)(nil).Validate()
+	var expect interface{} = fmt.Errorf("kocha: secureheaders: middleware is nil")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.SecureHeadersMiddleware.Validate() with nil => %#v; want %#v`, actual, expect)
+	}
+
+	for _, v := range []struct {
+		m      *kocha.SecureHeadersMiddleware
+		expect error
+	}{
+		{&kocha.SecureHeadersMiddleware{}, nil},
+		{&kocha.SecureHeadersMiddleware{FrameOptions: "sameorigin"}, nil},
+		{&kocha.SecureHeadersMiddleware{FrameOptions: "ALLOW-FROM https://example.com"}, fmt.Errorf(`kocha: secureheaders: FrameOptions must be DENY or SAMEORIGIN, but "ALLOW-FROM HTTPS://EXAMPLE.COM"`)},
+		{&kocha.SecureHeadersMiddleware{ContentSecurityPolicy: "default-src 'self'\r\nX-Evil: 1"}, fmt.Errorf("kocha: secureheaders: header value must not contain a newline")},
+	} {
+		actual = v.m.Validate()
+		expect = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.SecureHeadersMiddleware(%#v).Validate() => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat("kocha", 10)
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name: "compress",
+		Path: "/compress",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", r.FormValue("type"))
+			if encoding := r.FormValue("encoding"); encoding != "" {
+				w.Header().Set("Content-Encoding", encoding)
+			}
+			io.WriteString(w, r.FormValue("body"))
+		}),
+	})
+	config.Middlewares = []kocha.Middleware{
+		&kocha.CompressMiddleware{MinSize: 10},
+		&kocha.FormMiddleware{},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		acceptEncoding  string
+		contentType     string
+		contentEncoding string
+		body            string
+		expect          string
+		vary            string
+	}{
+		{"gzip, deflate", "text/html; charset=utf-8", "", body, "gzip", "Accept-Encoding"},
+		{"deflate, gzip;q=0.5", "application/json", "", body, "deflate", "Accept-Encoding"},
+		{"gzip;q=0, *", "text/plain", "", body, "deflate", "Accept-Encoding"},
+		{"identity", "text/plain", "", body, "", "Accept-Encoding"},
+		{"", "text/plain", "", body, "", "Accept-Encoding"},
+		{"gzip", "text/plain", "", "short", "", "Accept-Encoding"},
+		{"gzip", "image/png", "", body, "", ""},
+		{"gzip", "text/plain", "br", body, "br", ""},
+	} {
+		query := url.Values{"type": {v.contentType}, "encoding": {v.contentEncoding}, "body": {v.body}}
+		r, err := http.NewRequest("GET", "/compress?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept-Encoding", v.acceptEncoding)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		var actual interface{} = w.Header().Get("Content-Encoding")
+		var expect interface{} = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CompressMiddleware with %#v; Content-Encoding => %#v; want %#v`, v.acceptEncoding, actual, expect)
+		}
+		actual = w.Header().Get("Vary")
+		expect = v.vary
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CompressMiddleware with %#v; Vary => %#v; want %#v`, v.acceptEncoding, actual, expect)
+		}
+		var reader io.Reader = w.Body
+		switch v.expect {
+		case "gzip":
+			if reader, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatal(err)
+			}
+		case "deflate":
+			reader = flate.NewReader(w.Body)
+		}
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = string(b)
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CompressMiddleware with %#v; decoded body => %#v; want %#v`, v.acceptEncoding, actual, expect)
+		}
+	}
+}
+
+func TestCompressMiddleware_Validate(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.CompressMiddleware
+		expect interface{}
+	}{
+		{(*kocha.CompressMiddleware)(nil), fmt.Errorf("kocha: compress: middleware is nil")},
+		{&kocha.CompressMiddleware{Level: 10}, fmt.Errorf("kocha: compress: invalid compression level: 10")},
+		{&kocha.CompressMiddleware{Level: gzip.BestSpeed, NoCompression: true}, fmt.Errorf("kocha: compress: Level and NoCompression cannot be specified at the same time")},
+		{&kocha.CompressMiddleware{}, nil},
+	} {
+		actual := v.m.Validate()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.CompressMiddleware.Validate() with %#v => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+
+	for _, v := range []struct {
+		m       *kocha.CompressMiddleware
+		minSize int
+		level   int
+	}{
+		{&kocha.CompressMiddleware{}, kocha.DefaultCompressMinSize, gzip.DefaultCompression},
+		{&kocha.CompressMiddleware{MinSize: -1, Level: gzip.BestSpeed}, -1, gzip.BestSpeed},
+		{&kocha.CompressMiddleware{NoCompression: true}, kocha.DefaultCompressMinSize, gzip.NoCompression},
+	} {
+		if err := v.m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.m.Validate(); err != nil {
+			t.Errorf(`kocha.CompressMiddleware.Validate() twice with %#v => %#v; want %#v`, v.m, err, nil)
+		}
+		actual := []int{v.m.MinSize, v.m.Level}
+		expect := []int{v.minSize, v.level}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.CompressMiddleware.Validate(); [MinSize, Level] => %#v; want %#v`, actual, expect)
+		}
+	}
+}
+
+func TestCompressMiddleware_withNoCompression(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name: "compress",
+		Path: "/compress",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, r.FormValue("body"))
+		}),
+	})
+	config.Middlewares = []kocha.Middleware{
+		&kocha.CompressMiddleware{MinSize: -1, NoCompression: true},
+		&kocha.FormMiddleware{},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		body   string
+		expect string
+	}{
+		{"a", "gzip"},
+		{"", ""},
+	} {
+		r, err := http.NewRequest("GET", "/compress?"+url.Values{"body": {v.body}}.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		var actual interface{} = w.Header().Get("Content-Encoding")
+		var expect interface{} = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CompressMiddleware with body %#v; Content-Encoding => %#v; want %#v`, v.body, actual, expect)
+		}
+		var reader io.Reader = w.Body
+		if v.expect == "gzip" {
+			if reader, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = string(b)
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CompressMiddleware with body %#v; decoded body => %#v; want %#v`, v.body, actual, expect)
+		}
+	}
+}
+
+func TestETagMiddleware(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name: "etag",
+		Path: "/etag",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Last-Modified", "Tue, 01 Apr 2014 12:30:00 GMT")
+			if etag := r.FormValue("etag"); etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			io.WriteString(w, "kocha")
+		}),
+	})
+	config.Middlewares = []kocha.Middleware{
+		&kocha.ETagMiddleware{},
+		&kocha.FormMiddleware{},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const etag = `"fcc08a4a88e9c7a1cef30bbef679da0b8706a497"`
+	for _, v := range []struct {
+		method string
+		uri    string
+		header http.Header
+		status int
+		etag   string
+		body   string
+	}{
+		{"GET", "/etag", http.Header{}, http.StatusOK, etag, "kocha"},
+		{"GET", "/etag", http.Header{"If-None-Match": {etag}}, http.StatusNotModified, etag, ""},
+		{"HEAD", "/etag", http.Header{"If-None-Match": {`"other", W/` + etag}}, http.StatusNotModified, etag, ""},
+		{"GET", "/etag", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified, etag, ""},
+		{"GET", "/etag", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK, etag, "kocha"},
+		{"GET", "/etag?etag=%22v1%22", http.Header{"If-None-Match": {`"v1"`}}, http.StatusNotModified, `"v1"`, ""},
+		{"GET", "/etag", http.Header{"If-Modified-Since": {"Tue, 01 Apr 2014 12:30:00 GMT"}}, http.StatusNotModified, etag, ""},
+		{"GET", "/etag", http.Header{"If-Modified-Since": {"Tue, 01 Apr 2014 12:29:59 GMT"}}, http.StatusOK, etag, "kocha"},
+		{"GET", "/etag", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {"Tue, 01 Apr 2014 12:30:00 GMT"}}, http.StatusOK, etag, "kocha"},
+		{"POST", "/etag", http.Header{"If-None-Match": {etag}}, http.StatusOK, "", "kocha"},
+	} {
+		r, err := http.NewRequest(v.method, v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header = v.header
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`ETagMiddleware with %s %#v %#v; status => %#v; want %#v`, v.method, v.uri, v.header, actual, expect)
+		}
+		actual = w.Header().Get("ETag")
+		expect = v.etag
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`ETagMiddleware with %s %#v %#v; ETag => %#v; want %#v`, v.method, v.uri, v.header, actual, expect)
+		}
+		if v.method == "HEAD" {
+			continue
+		}
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`ETagMiddleware with %s %#v %#v; body => %#v; want %#v`, v.method, v.uri, v.header, actual, expect)
+		}
+	}
+}
+
+func TestContentNegotiationMiddleware(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.Middlewares = []kocha.Middleware{
+		&kocha.ContentNegotiationMiddleware{
+			Formats:       []string{"html", "json"},
+			PathExtension: true,
+			ParamName:     "format",
+		},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := "This is layout\nThis is user 1\n\n"
+	json := "{\n  \"layout\": \"application\",\n  \"id\": \"1\"\n\n}\n"
+	for _, v := range []struct {
+		uri         string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/user/1", "", http.StatusOK, "text/html", html},
+		{"/user/1", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", http.StatusOK, "text/html", html},
+		{"/user/1", "application/json", http.StatusOK, "application/json", json},
+		{"/user/1", "text/html;q=0.5, application/json", http.StatusOK, "application/json", json},
+		{"/user/1", "application/*", http.StatusOK, "application/json", json},
+		{"/user/1", "*/*;q=0.1, text/html;q=0", http.StatusOK, "application/json", json},
+		{"/user/1", "application/xml", http.StatusNotAcceptable, "text/html", "This is layout\n406 error\n\n"},
+		{"/user/1.json", "text/html", http.StatusOK, "application/json", json},
+		{"/user/1.txt", "", http.StatusOK, "text/html", "This is layout\nThis is user 1.txt\n\n"},
+		{"/user/1?format=json", "", http.StatusOK, "application/json", json},
+		{"/user/1?format=xml", "", http.StatusNotAcceptable, "text/html", "This is layout\n406 error\n\n"},
+		{"/", "application/json", http.StatusNotAcceptable, "text/html", "This is layout\n406 error\n\n"},
+	} {
+		r, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.accept != "" {
+			r.Header.Set("Accept", v.accept)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		actual := []interface{}{w.Code, w.Header().Get("Content-Type"), w.Body.String()}
+		expect := []interface{}{v.status, v.contentType, v.body}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with "Accept: %v" => %#v; want %#v`, v.uri, v.accept, actual, expect)
+		}
+	}
+}
+
+type testRenderCtrl struct {
+	*kocha.DefaultController
+	render func(c *kocha.Context) error
+}
+
+func (ctrl *testRenderCtrl) GET(c *kocha.Context) error {
+	return ctrl.render(c)
+}
+
+func TestContentNegotiationMiddleware_withFixedTypeRenderer(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	for name, render := range map[string]func(c *kocha.Context) error{
+		"json": func(c *kocha.Context) error {
+			return c.RenderJSON(map[string]string{"a": "<script>"})
+		},
+		"csv": func(c *kocha.Context) error {
+			return c.RenderAs("text/csv", [][]string{{"a", "<script>"}})
+		},
+		"text": func(c *kocha.Context) error {
+			return c.RenderText("<script>")
+		},
+		"custom": func(c *kocha.Context) error {
+			c.Response.ContentType = "application/vnd.test+json"
+			return c.RenderJSON(map[string]string{"a": "<script>"})
+		},
+	} {
+		config.RouteTable = append(config.RouteTable, &kocha.Route{
+			Name:       "render_" + name,
+			Path:       "/render/" + name,
+			Controller: &testRenderCtrl{render: render},
+		})
+	}
+	config.Middlewares = []kocha.Middleware{
+		&kocha.ContentNegotiationMiddleware{Formats: []string{"html", "json"}},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri         string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"/render/json", "text/html", "application/json", `{"a":"\u003cscript\u003e"}`},
+		{"/render/json", "application/json", "application/json", `{"a":"\u003cscript\u003e"}`},
+		{"/render/csv", "text/html", "text/csv", "a,<script>\n"},
+		{"/render/text", "text/html", "text/plain", "<script>"},
+		{"/render/custom", "text/html", "application/vnd.test+json", `{"a":"\u003cscript\u003e"}`},
+	} {
+		r, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept", v.accept)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		actual := []interface{}{w.Code, w.Header().Get("Content-Type"), w.Body.String()}
+		expect := []interface{}{http.StatusOK, v.contentType, v.body}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with "Accept: %v" => %#v; want %#v`, v.uri, v.accept, actual, expect)
+		}
+	}
+}
+
+func TestContentNegotiationMiddleware_Validate(t *testing.T) {
+	var actual interface{} = (*kocha.ContentNegotiationMiddleware)(nil).Validate()
+	var expect interface{} = fmt.Errorf("kocha: negotiation: middleware is nil")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.ContentNegotiationMiddleware.Validate() with nil => %#v; want %#v`, actual, expect)
+	}
+
+	m := &kocha.ContentNegotiationMiddleware{Formats: []string{"html", "yaml"}}
+	actual = m.Validate()
+	expect = fmt.Errorf(`kocha: negotiation: format "yaml" isn't registered in MimeTypeFormats`)
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.ContentNegotiationMiddleware(%#v).Validate() => %#v; want %#v`, m, actual, expect)
+	}
+
+	m = &kocha.ContentNegotiationMiddleware{Formats: []string{"msgpack", "CSV"}}
+	actual = m.Validate()
+	expect = nil
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.ContentNegotiationMiddleware(%#v).Validate() => %#v; want %#v`, m, actual, expect)
+	}
+
+	m = &kocha.ContentNegotiationMiddleware{}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	actual = m.Formats
+	expect = []string{"html"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.ContentNegotiationMiddleware.Validate(); Formats => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	origNow := util.Now
+	util.Now = func() time.Time { return time.Unix(1383820443, 0) }
+	defer func() {
+		util.Now = origNow
+	}()
+
+	for _, v := range []struct {
+		m       *kocha.RateLimitMiddleware
+		remotes []string
+		uris    []string
+		expect  []int
+	}{
+		{&kocha.RateLimitMiddleware{Limit: 2, Period: time.Minute},
+			[]string{"1.1.1.1", "1.1.1.1", "2.2.2.2", "1.1.1.1"},
+			[]string{"/", "/user/1", "/", "/"},
+			[]int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		{&kocha.RateLimitMiddleware{Limit: 1, Period: time.Minute, PerRoute: true},
+			[]string{"1.1.1.1", "1.1.1.1", "1.1.1.1", "1.1.1.1"},
+			[]string{"/", "/user/1", "/user/2", "/"},
+			[]int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+		{&kocha.RateLimitMiddleware{Limit: 1, Period: time.Minute, KeyFunc: func(c *kocha.Context) string { return "same" }},
+			[]string{"1.1.1.1", "2.2.2.2"},
+			[]string{"/", "/"},
+			[]int{http.StatusOK, http.StatusTooManyRequests}},
+	} {
+		config := kocha.NewTestApp().Config
+		config.Middlewares = []kocha.Middleware{v.m, &kocha.DispatchMiddleware{}}
+		app, err := kocha.New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []int
+		for i, uri := range v.uris {
+			r, err := http.NewRequest("GET", uri, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = v.remotes[i] + ":12345"
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			actual = append(actual, w.Code)
+		}
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RateLimitMiddleware %#v; statuses => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+
+	config := kocha.NewTestApp().Config
+	config.Middlewares = []kocha.Middleware{&kocha.RateLimitMiddleware{Limit: 1, Period: time.Minute}, &kocha.DispatchMiddleware{}}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []http.Header{
+		{"Ratelimit-Limit": {"1"}, "Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"60"}},
+		{"Ratelimit-Limit": {"1"}, "Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"60"}, "Retry-After": {"60"}},
+	} {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		actual := http.Header{}
+		for _, key := range []string{"Ratelimit-Limit", "Ratelimit-Remaining", "Ratelimit-Reset", "Retry-After"} {
+			if value, found := w.Header()[key]; found {
+				actual[key] = value
+			}
+		}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RateLimitMiddleware; headers => %#v; want %#v`, actual, expect)
+		}
+	}
+}
+
+func TestRateLimitMiddleware_Validate(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.RateLimitMiddleware
+		expect interface{}
+	}{
+		{(*kocha.RateLimitMiddleware)(nil), fmt.Errorf("kocha: ratelimit: middleware is nil")},
+		{&kocha.RateLimitMiddleware{Period: time.Second}, fmt.Errorf("kocha: ratelimit: Limit must be greater than 0")},
+		{&kocha.RateLimitMiddleware{Limit: 1}, fmt.Errorf("kocha: ratelimit: Period must be greater than 0")},
+		{&kocha.RateLimitMiddleware{Limit: 1, Period: time.Second}, nil},
+	} {
+		actual := v.m.Validate()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.RateLimitMiddleware.Validate() with %#v => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	waitDone := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+		fmt.Fprint(w, "done")
+	})
+	config := kocha.NewTestApp().Config
+	config.Timeout = 10 * time.Millisecond
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name:    "slow",
+		Path:    "/slow",
+		Handler: waitDone,
+	}, &kocha.Route{
+		Name:    "long",
+		Path:    "/long",
+		Timeout: time.Hour,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok := r.Context().Deadline()
+			fmt.Fprint(w, r.Context().Err() == nil && ok && deadline.Sub(time.Now()) > time.Minute)
+		}),
+	})
+	config.Middlewares = []kocha.Middleware{
+		&kocha.TimeoutMiddleware{},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri      string
+		canceled bool
+		status   int
+		body     string
+	}{
+		{"/slow", false, http.StatusGatewayTimeout, "This is layout\n504 error\n\n"},
+		{"/slow", true, http.StatusServiceUnavailable, "This is layout\n503 error\n\n"},
+		{"/long", false, http.StatusOK, "true"},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if v.canceled {
+			cancel()
+		}
+		r, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r.WithContext(ctx))
+		cancel()
+
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with canceled %v; status => %#v; want %#v`, v.uri, v.canceled, actual, expect)
+		}
+
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v with canceled %v => %#v; want %#v`, v.uri, v.canceled, actual, expect)
+		}
+	}
+}
+
+func TestTimeoutMiddleware_Validate(t *testing.T) {
+	var actual interface{} = (*kocha.TimeoutMiddleware)(nil).Validate()
+	var expect interface{} = fmt.Errorf("kocha: timeout: middleware is nil")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.TimeoutMiddleware.Validate() with nil => %#v; want %#v`, actual, expect)
+	}
+
+	for _, v := range []struct {
+		m      *kocha.TimeoutMiddleware
+		expect error
+	}{
+		{&kocha.TimeoutMiddleware{}, nil},
+		{&kocha.TimeoutMiddleware{StatusCode: http.StatusServiceUnavailable}, nil},
+		{&kocha.TimeoutMiddleware{StatusCode: http.StatusOK}, fmt.Errorf("kocha: timeout: StatusCode must be an error status code, but 200")},
+	} {
+		actual = v.m.Validate()
+		expect = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`kocha.TimeoutMiddleware(%#v).Validate() => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+
+	m := &kocha.TimeoutMiddleware{}
+	m.Validate()
+	actual = m.StatusCode
+	expect = http.StatusGatewayTimeout
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`kocha.TimeoutMiddleware.Validate(); StatusCode => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	config := kocha.NewTestApp().Config
+	config.Logger = &kocha.LoggerConfig{
+		Writer:    &buf,
+		Formatter: &log.LTSVFormatter{},
+	}
+	config.Middlewares = []kocha.Middleware{
+		&kocha.RequestIDMiddleware{Generator: func() string { return "generated" }},
+		&kocha.RequestLoggingMiddleware{},
+		&kocha.PanicRecoverMiddleware{},
+		&kocha.DispatchMiddleware{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri    string
+		header string
+		expect string
+	}{
+		{"/", "", "generated"},
+		{"/", "abc-123", "abc-123"},
+		{"/", "invalid id", "generated"},
+		{"/error", "abc-456", "abc-456"},
+	} {
+		buf.Reset()
+		r, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.header != "" {
+			r.Header.Set("X-Request-ID", v.header)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		var actual interface{} = w.Header().Get("X-Request-ID")
+		var expect interface{} = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RequestIDMiddleware with %#v; X-Request-ID => %#v; want %#v`, v.header, actual, expect)
+		}
+		logs := buf.String()
+		entries := strings.Count(logs, "level:")
+		if v.uri == "/error" && entries < 2 {
+			t.Errorf(`RequestIDMiddleware with %#v; log => %#v; want the error and the request`, v.header, logs)
+		}
+		if n := strings.Count(logs, "\trequest_id:"+v.expect); n != entries {
+			t.Errorf(`RequestIDMiddleware with %#v; log => %#v; want request_id:%v in all entries`, v.header, logs, v.expect)
+		}
+	}
+}
+
 func TestFlashMiddleware_Before_withNilSession(t *testing.T) {
 	app := kocha.NewTestApp()
 	m := &kocha.FlashMiddleware{}
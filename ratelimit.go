@@ -0,0 +1,107 @@
+package kocha
+
+import (
+	"sync"
+	"time"
+
+	"github.com/woremacx/kocha/util"
+)
+
+// RateLimitStore is the interface that rate limit store.
+type RateLimitStore interface {
+	// Take takes a request from the quota of key that allows limit requests
+	// per period.
+	Take(key string, limit int, period time.Duration) (RateLimitResult, error)
+}
+
+// RateLimitResult represents a result of RateLimitStore.Take.
+type RateLimitResult struct {
+	// Whether the request is allowed.
+	Allowed bool
+
+	// Number of the remaining requests.
+	Remaining int
+
+	// Time until the quota is fully restored.
+	Reset time.Duration
+
+	// Time until the next request is allowed.
+	// It is only set if the request isn't allowed.
+	RetryAfter time.Duration
+}
+
+// MemoryRateLimitStore is an in-memory implementation of RateLimitStore by
+// the token bucket algorithm.
+//
+// The states are held per process, so it cannot be shared among the
+// multiple processes.
+type MemoryRateLimitStore struct {
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryRateLimitStore returns a new MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: util.Now(),
+	}
+}
+
+// Take implements the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(key string, limit int, period time.Duration) (RateLimitResult, error) {
+	now := util.Now()
+	rate := float64(limit) / float64(period)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) > period {
+		s.sweep(now)
+	}
+	b := s.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(limit), last: now}
+		s.buckets[key] = b
+	}
+	b.limit, b.rate = float64(limit), rate
+	b.refill(now)
+	var result RateLimitResult
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(limit) - b.tokens) / rate)
+	return result, nil
+}
+
+// sweep removes the buckets that have been fully refilled.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.refill(now); b.tokens >= b.limit {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// tokenBucket represents a bucket of the token bucket algorithm.
+type tokenBucket struct {
+	tokens float64   // number of the tokens.
+	last   time.Time // last time of refill.
+	limit  float64   // capacity of the bucket.
+	rate   float64   // tokens per nanosecond.
+}
+
+// refill adds the tokens that are accumulated since the last time.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) * b.rate
+		if b.tokens > b.limit {
+			b.tokens = b.limit
+		}
+	}
+	b.last = now
+}
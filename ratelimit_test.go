@@ -0,0 +1,45 @@
+package kocha_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/woremacx/kocha"
+	"github.com/woremacx/kocha/util"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	now := time.Unix(1383820443, 0)
+	origNow := util.Now
+	util.Now = func() time.Time { return now }
+	defer func() {
+		util.Now = origNow
+	}()
+
+	store := kocha.NewMemoryRateLimitStore()
+	for _, v := range []struct {
+		elapsed time.Duration
+		key     string
+		expect  kocha.RateLimitResult
+	}{
+		{0, "a", kocha.RateLimitResult{Allowed: true, Remaining: 2, Reset: 20 * time.Second}},
+		{0, "a", kocha.RateLimitResult{Allowed: true, Remaining: 1, Reset: 40 * time.Second}},
+		{0, "a", kocha.RateLimitResult{Allowed: true, Remaining: 0, Reset: 60 * time.Second}},
+		{0, "a", kocha.RateLimitResult{Allowed: false, Remaining: 0, Reset: 60 * time.Second, RetryAfter: 20 * time.Second}},
+		{0, "b", kocha.RateLimitResult{Allowed: true, Remaining: 2, Reset: 20 * time.Second}},
+		{10 * time.Second, "a", kocha.RateLimitResult{Allowed: false, Remaining: 0, Reset: 50 * time.Second, RetryAfter: 10 * time.Second}},
+		{10 * time.Second, "a", kocha.RateLimitResult{Allowed: true, Remaining: 0, Reset: 60 * time.Second}},
+		{2 * time.Minute, "a", kocha.RateLimitResult{Allowed: true, Remaining: 2, Reset: 20 * time.Second}},
+	} {
+		now = now.Add(v.elapsed)
+		actual, err := store.Take(v.key, 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`MemoryRateLimitStore.Take(%#v, 3, time.Minute) after %v => %#v; want %#v`, v.key, v.elapsed, actual, expect)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package kocha
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+)
+
+var (
+	defaultJSONConfig = &JSONConfig{Marshaler: &JSONMarshaler{}}
+	defaultXMLConfig  = &XMLConfig{Marshaler: &XMLMarshaler{}}
+
+	jsonpCallbackRegexp = regexp.MustCompile(`\A[a-zA-Z_$][0-9a-zA-Z_$]*(?:\.[a-zA-Z_$][0-9a-zA-Z_$]*)*\z`)
+)
+
+// maxJSONPCallbackLength is the maximum length of the JSONP callback name.
+const maxJSONPCallbackLength = 128
+
+// JSONConfig represents the configuration of Context.RenderJSON.
+type JSONConfig struct {
+	// Indentation for the pretty printing such as "  ".
+	// If empty, the JSON is compact.
+	Indent string
+
+	// Whether to disable the escaping of "<", ">" and "&" in the strings.
+	DisableHTMLEscape bool
+
+	// Name of the query parameter that holds the JSONP callback such as
+	// "callback". If empty, JSONP is disabled.
+	// The callback must be a JavaScript identifier or the dotted identifiers,
+	// otherwise it renders the HTTP 400 Bad Request.
+	JSONPCallbackParam string
+
+	// Whether to encode the data directly to the client without the
+	// buffering. The elements of a slice are encoded one by one.
+	// See Context.Stream.
+	Stream bool
+
+	// Marshaler to encode the data. Default is JSONMarshaler.
+	Marshaler Marshaler
+}
+
+// XMLConfig represents the configuration of Context.RenderXML.
+type XMLConfig struct {
+	// Indentation for the pretty printing such as "  ".
+	// If empty, the XML is compact.
+	Indent string
+
+	// Whether to write the XML header such as `<?xml version="1.0"...?>`
+	// before the data.
+	Header bool
+
+	// Whether to encode the data directly to the client without the
+	// buffering. The elements of a slice are encoded one by one in the
+	// element of StreamRoot. See Context.Stream.
+	Stream bool
+
+	// Name of the root element that wraps the elements of a slice on the
+	// streaming such as "users". It's required to stream a slice because
+	// XML must have the single root element.
+	StreamRoot string
+
+	// Marshaler to encode the data. Default is XMLMarshaler.
+	Marshaler Marshaler
+}
+
+// MarshalOptions represents the options of Marshaler.
+type MarshalOptions struct {
+	Indent     string // indentation for the pretty printing.
+	EscapeHTML bool   // whether to escape HTML characters. (JSON only)
+}
+
+// Encoder is an interface to encode the values to the stream.
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Marshaler is an interface to encode the data of Context.RenderJSON and
+// Context.RenderXML. It can be used in order to replace the encoding with
+// the other library.
+type Marshaler interface {
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}, opts MarshalOptions) ([]byte, error)
+
+	// NewEncoder returns a new Encoder that writes to w.
+	NewEncoder(w io.Writer, opts MarshalOptions) Encoder
+}
+
+// JSONMarshaler implements Marshaler interface by encoding/json.
+type JSONMarshaler struct{}
+
+// Marshal returns the JSON encoding of v.
+func (m *JSONMarshaler) Marshal(v interface{}, opts MarshalOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.NewEncoder(&buf, opts).Encode(v); err != nil {
+		return nil, err
+	}
+	// json.Encoder terminates each value with a newline.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// NewEncoder returns a new json.Encoder that writes to w.
+func (m *JSONMarshaler) NewEncoder(w io.Writer, opts MarshalOptions) Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(opts.EscapeHTML)
+	if opts.Indent != "" {
+		enc.SetIndent("", opts.Indent)
+	}
+	return enc
+}
+
+// XMLMarshaler implements Marshaler interface by encoding/xml.
+type XMLMarshaler struct{}
+
+// Marshal returns the XML encoding of v.
+func (m *XMLMarshaler) Marshal(v interface{}, opts MarshalOptions) ([]byte, error) {
+	if opts.Indent != "" {
+		return xml.MarshalIndent(v, "", opts.Indent)
+	}
+	return xml.Marshal(v)
+}
+
+// NewEncoder returns a new xml.Encoder that writes to w.
+func (m *XMLMarshaler) NewEncoder(w io.Writer, opts MarshalOptions) Encoder {
+	enc := xml.NewEncoder(w)
+	if opts.Indent != "" {
+		enc.Indent("", opts.Indent)
+	}
+	return enc
+}
+
+// isValidJSONPCallback returns whether the callback is safe to be used as
+// the JSONP callback.
+func isValidJSONPCallback(callback string) bool {
+	return len(callback) <= maxJSONPCallbackLength && jsonpCallbackRegexp.MatchString(callback)
+}
+
+// Renderer is an interface to render the data in a content type.
+// The renderers that are registered by RegisterRenderer can be used by
+// Context.RenderAs. e.g. for Protocol Buffers:
+//
+//	type ProtobufRenderer struct{}
+//
+//	func (r *ProtobufRenderer) Render(w io.Writer, data interface{}) error {
+//	    buf, err := proto.Marshal(data.(proto.Message))
+//	    if err != nil {
+//	        return err
+//	    }
+//	    _, err = w.Write(buf)
+//	    return err
+//	}
+//
+//	kocha.RegisterRenderer("application/x-protobuf", &ProtobufRenderer{})
+//
+// and in the controller:
+//
+//	return c.RenderAs("application/x-protobuf", message)
+type Renderer interface {
+	// Render writes the encoding of data to w.
+	Render(w io.Writer, data interface{}) error
+}
+
+// renderers is a map of the content type and the Renderer.
+var renderers = struct {
+	m  map[string]Renderer
+	mu sync.RWMutex
+}{
+	m: map[string]Renderer{
+		"application/x-msgpack": &MsgpackRenderer{},
+		"text/csv":              &CSVRenderer{},
+	},
+}
+
+// RegisterRenderer registers the renderer of the content type.
+// If already registered, it overwrites. If r is nil, the renderer of the
+// content type is unregistered.
+func RegisterRenderer(contentType string, r Renderer) {
+	renderers.mu.Lock()
+	defer renderers.mu.Unlock()
+	if r == nil {
+		delete(renderers.m, contentType)
+		return
+	}
+	renderers.m[contentType] = r
+}
+
+// lookupRenderer returns the renderer of the content type.
+// It returns nil if not registered.
+func lookupRenderer(contentType string) Renderer {
+	renderers.mu.RLock()
+	defer renderers.mu.RUnlock()
+	return renderers.m[contentType]
+}
+
+// MsgpackRenderer implements Renderer interface for MessagePack.
+type MsgpackRenderer struct{}
+
+// Render writes the MessagePack encoding of data to w.
+func (r *MsgpackRenderer) Render(w io.Writer, data interface{}) error {
+	return codec.NewEncoder(w, codecHandler).Encode(data)
+}
+
+// CSVRenderer implements Renderer interface for CSV.
+//
+// The data must be [][]string or a slice of the structs.
+// If the data is a slice of the structs, the first row is the header that
+// consists of the column names, and each struct is rendered as a row.
+// The column name of the field can be specified by "csv" struct tag such as
+// `csv:"name"`. If the tag is "-", the field is ignored. If the tag is
+// empty, the field name is used.
+type CSVRenderer struct{}
+
+// Render writes the CSV encoding of data to w.
+func (r *CSVRenderer) Render(w io.Writer, data interface{}) error {
+	cw := csv.NewWriter(w)
+	if records, ok := data.([][]string); ok {
+		return cw.WriteAll(records)
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("kocha: csv: unsupported type: %T", data)
+	}
+	t := v.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("kocha: csv: unsupported type: %T", data)
+	}
+	var header []string
+	var indexes []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Tag.Get("csv")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		header = append(header, name)
+		indexes = append(indexes, i)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(indexes))
+	for i := 0; i < v.Len(); i++ {
+		elem := reflect.Indirect(v.Index(i))
+		for j, index := range indexes {
+			if !elem.IsValid() {
+				record[j] = ""
+				continue
+			}
+			record[j] = csvValue(elem.Field(index))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvValue returns the string representation of v for CSV.
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -1,8 +1,11 @@
 package kocha
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -18,26 +21,42 @@ type Request struct {
 	*http.Request
 
 	// RemoteAddr is similar to http.Request.RemoteAddr, but IP only.
+	// If the direct peer is a trusted proxy, it is the address of the client
+	// that is taken from the Forwarded or X-Forwarded-For header.
 	RemoteAddr string
+
+	trusted bool   // whether the direct peer is a trusted proxy.
+	proto   string // proto of the client in the Forwarded header.
 }
 
 // newRequest returns a new Request that given a *http.Request.
-func newRequest(req *http.Request) *Request {
+// The forwarded headers are honored only if the direct peer is in
+// trustedProxies.
+func newRequest(req *http.Request, trustedProxies []*net.IPNet) *Request {
 	r := requestPool.Get().(*Request)
 	r.Request = req
-	r.RemoteAddr = remoteAddr(req)
+	r.RemoteAddr, r.trusted, r.proto = clientAddr(req, trustedProxies)
 	return r
 }
 
 // Scheme returns current scheme of HTTP connection.
+// The forwarded headers such as X-Forwarded-Proto are honored only if the
+// direct peer is a trusted proxy.
 func (r *Request) Scheme() string {
-	switch {
-	case r.Header.Get("Https") == "on", r.Header.Get("X-Forwarded-Ssl") == "on":
+	if r.trusted {
+		switch {
+		case r.proto != "":
+			return r.proto
+		case r.Header.Get("Https") == "on", r.Header.Get("X-Forwarded-Ssl") == "on":
+			return "https"
+		case r.Header.Get("X-Forwarded-Scheme") != "":
+			return r.Header.Get("X-Forwarded-Scheme")
+		case r.Header.Get("X-Forwarded-Proto") != "":
+			return strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
+		}
+	}
+	if r.TLS != nil {
 		return "https"
-	case r.Header.Get("X-Forwarded-Scheme") != "":
-		return r.Header.Get("X-Forwarded-Scheme")
-	case r.Header.Get("X-Forwarded-Proto") != "":
-		return strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
 	}
 	return "http"
 }
@@ -56,13 +75,199 @@ func (r *Request) reuse() {
 	requestPool.Put(r)
 }
 
-func remoteAddr(r *http.Request) string {
-	if addr := r.Header.Get("X-Forwarded-For"); addr != "" {
-		return strings.TrimSpace(addr[strings.LastIndex(addr, ",")+1:])
+// clientAddr returns the address of the client, whether the direct peer is
+// a trusted proxy, and the proto of the client in the Forwarded header.
+// The forwarded addresses are examined from the nearest one, and the first
+// address that isn't a trusted proxy is the client. If the forwarded address
+// isn't an IP address such as "unknown" or the obfuscated identifier, the
+// address of the peer that has forwarded it is regarded as the client.
+func clientAddr(r *http.Request, trustedProxies []*net.IPNet) (addr string, trusted bool, proto string) {
+	addr = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !isTrustedProxy(addr, trustedProxies) {
+		return addr, false, ""
+	}
+	var hops []forwardedElement
+	if header := r.Header.Get("Forwarded"); header != "" {
+		hops = parseForwarded(header)
+	} else if header := r.Header.Get("X-Forwarded-For"); header != "" {
+		for _, v := range strings.Split(header, ",") {
+			hops = append(hops, forwardedElement{addr: strings.TrimSpace(v)})
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i].addr) == nil {
+			if hops[i].proto != "" {
+				proto = hops[i].proto
+			}
+			break
+		}
+		addr, proto = hops[i].addr, hops[i].proto
+		if !isTrustedProxy(addr, trustedProxies) {
+			break
+		}
+	}
+	return addr, true, proto
+}
+
+func isTrustedProxy(addr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the CIDR notations or the IP addresses.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var ipnets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("kocha: invalid trusted proxy: %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			ipnets = append(ipnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("kocha: invalid trusted proxy: %q", proxy)
+		}
+		ipnets = append(ipnets, ipnet)
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	return ipnets, nil
+}
+
+// forwardedElement represents an element of the Forwarded header.
+type forwardedElement struct {
+	addr  string // address of "for" parameter without port.
+	proto string // value of "proto" parameter.
+}
+
+// parseForwarded parses the Forwarded header that is defined in RFC 7239.
+func parseForwarded(header string) []forwardedElement {
+	var elements []forwardedElement
+	for _, e := range splitQuoted(header, ',') {
+		var element forwardedElement
+		for _, pair := range splitQuoted(e, ';') {
+			i := strings.IndexByte(pair, '=')
+			if i < 0 {
+				continue
+			}
+			key, value := strings.ToLower(strings.TrimSpace(pair[:i])), strings.TrimSpace(pair[i+1:])
+			if uq, err := strconv.Unquote(value); err == nil {
+				value = uq
+			}
+			switch key {
+			case "for":
+				element.addr = forwardedNodeAddr(value)
+			case "proto":
+				element.proto = strings.ToLower(value)
+			}
+		}
+		elements = append(elements, element)
 	}
-	return host
+	return elements
+}
+
+// forwardedNodeAddr returns the address of the node such as
+// "192.0.2.43:8080" or "[2001:db8:cafe::17]" without the port.
+func forwardedNodeAddr(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i >= 0 {
+			return node[1:i]
+		}
+		return node
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// splitQuoted splits s by sep that isn't in the quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var result []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case sep:
+			if !quoted {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, s[start:])
+}
+
+// acceptSpec represents an element of the Accept family headers.
+type acceptSpec struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses the value of the Accept family headers such as
+// Accept-Encoding, and returns the elements that are sorted by the quality
+// value in descending order. The elements that have same quality value keep
+// the order of header.
+func parseAccept(header string) []acceptSpec {
+	var specs []acceptSpec
+	for _, s := range strings.Split(header, ",") {
+		params := strings.Split(s, ";")
+		spec := acceptSpec{value: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if spec.value == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") && !strings.HasPrefix(param, "Q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(param[len("q="):], 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			spec.q = q
+		}
+		specs = append(specs, spec)
+	}
+	sort.Stable(acceptSpecsByQuality(specs))
+	return specs
+}
+
+// acceptSpecsByQuality implements sort.Interface interface.
+type acceptSpecsByQuality []acceptSpec
+
+// Len implements sort.Interface.Len.
+func (specs acceptSpecsByQuality) Len() int {
+	return len(specs)
+}
+
+// Less implements sort.Interface.Less.
+func (specs acceptSpecsByQuality) Less(i, j int) bool {
+	return specs[i].q > specs[j].q
+}
+
+// Swap implements sort.Interface.Swap.
+func (specs acceptSpecsByQuality) Swap(i, j int) {
+	specs[i], specs[j] = specs[j], specs[i]
 }
@@ -1,56 +1,98 @@
 package kocha
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"reflect"
 	"testing"
 )
 
 func TestRequest_RemoteAddr(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, v := range []struct {
-		header string
-		value  string
-		expect string
+		remoteAddr string
+		header     string
+		value      string
+		expect     string
 	}{
-		{"X-Forwarded-For", "192.168.0.1", "192.168.0.1"},
-		{"X-Forwarded-For", "192.168.0.1, 192.168.0.2, 192.168.0.3", "192.168.0.3"},
-		{"X-Forwarded-For", "", "127.0.0.1"},
+		{"127.0.0.1:12345", "X-Forwarded-For", "192.168.0.1", "192.168.0.1"},
+		{"127.0.0.1:12345", "X-Forwarded-For", "192.168.0.1, 192.168.0.2, 192.168.0.3", "192.168.0.3"},
+		{"127.0.0.1:12345", "X-Forwarded-For", "192.168.0.1, 192.168.0.2, 10.0.0.1", "192.168.0.2"},
+		{"127.0.0.1:12345", "X-Forwarded-For", "10.0.0.2, 10.0.0.1", "10.0.0.2"},
+		{"127.0.0.1:12345", "X-Forwarded-For", "", "127.0.0.1"},
+		{"192.168.0.10:12345", "X-Forwarded-For", "192.168.0.1", "192.168.0.10"},
+		{"127.0.0.1:12345", "Forwarded", `for=192.0.2.60;proto=http;by=203.0.113.43`, "192.0.2.60"},
+		{"127.0.0.1:12345", "Forwarded", `for="[2001:db8:cafe::17]:4711", for=10.0.0.3`, "2001:db8:cafe::17"},
+		{"127.0.0.1:12345", "Forwarded", `For="192.0.2.43:8080"`, "192.0.2.43"},
+		{"192.168.0.10:12345", "Forwarded", `for=192.0.2.60`, "192.168.0.10"},
+		{"127.0.0.1:12345", "Forwarded", `for=unknown`, "127.0.0.1"},
+		{"127.0.0.1:12345", "Forwarded", `for="_hidden", for=10.0.0.3`, "10.0.0.3"},
+		{"127.0.0.1:12345", "Forwarded", `for=192.0.2.60, for=_hidden`, "127.0.0.1"},
+		{"127.0.0.1:12345", "Forwarded", `proto=https`, "127.0.0.1"},
+		{"127.0.0.1:12345", "X-Forwarded-For", "192.168.0.1, garbage", "127.0.0.1"},
 	} {
-		r := &http.Request{Header: make(http.Header), RemoteAddr: "127.0.0.1:12345"}
+		r := &http.Request{Header: make(http.Header), RemoteAddr: v.remoteAddr}
 		r.Header.Set(v.header, v.value)
-		req := newRequest(r)
+		req := newRequest(r, trustedProxies)
 		actual := req.RemoteAddr
 		expect := v.expect
 		if !reflect.DeepEqual(actual, expect) {
-			t.Errorf(`Request.RemoteAddr with "%v: %v" => %#v; want %#v`, v.header, v.value, actual, expect)
+			t.Errorf(`Request.RemoteAddr from %v with "%v: %v" => %#v; want %#v`, v.remoteAddr, v.header, v.value, actual, expect)
 		}
 	}
 }
 
 func TestRequest_Scheme(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, v := range []struct {
-		header string
-		value  string
-		expect string
+		remoteAddr string
+		header     string
+		value      string
+		expect     string
 	}{
-		{"HTTPS", "on", "https"},
-		{"X-Forwarded-SSL", "on", "https"},
-		{"X-Forwarded-Scheme", "file", "file"},
-		{"X-Forwarded-Proto", "gopher", "gopher"},
-		{"X-Forwarded-Proto", "https, http, file", "https"},
+		{"127.0.0.1:12345", "HTTPS", "on", "https"},
+		{"127.0.0.1:12345", "X-Forwarded-SSL", "on", "https"},
+		{"127.0.0.1:12345", "X-Forwarded-Scheme", "file", "file"},
+		{"127.0.0.1:12345", "X-Forwarded-Proto", "gopher", "gopher"},
+		{"127.0.0.1:12345", "X-Forwarded-Proto", "https, http, file", "https"},
+		{"127.0.0.1:12345", "Forwarded", "for=192.0.2.60;proto=https, for=10.0.0.1;proto=http", "https"},
+		{"127.0.0.1:12345", "Forwarded", "for=_hidden;proto=https", "https"},
+		{"192.168.0.10:12345", "HTTPS", "on", "http"},
+		{"192.168.0.10:12345", "X-Forwarded-Proto", "https", "http"},
+		{"192.168.0.10:12345", "Forwarded", "for=192.0.2.60;proto=https", "http"},
 	} {
-		req := &Request{Request: &http.Request{Header: make(http.Header)}}
-		req.Header.Set(v.header, v.value)
+		r := &http.Request{Header: make(http.Header), RemoteAddr: v.remoteAddr}
+		r.Header.Set(v.header, v.value)
+		req := newRequest(r, trustedProxies)
 		actual := req.Scheme()
 		expect := v.expect
 		if !reflect.DeepEqual(actual, expect) {
-			t.Errorf(`Request.Scheme() with "%v: %v" => %#v; want %#v`, v.header, v.value, actual, expect)
+			t.Errorf(`Request.Scheme() from %v with "%v: %v" => %#v; want %#v`, v.remoteAddr, v.header, v.value, actual, expect)
 		}
 	}
+
+	req := &Request{Request: &http.Request{Header: make(http.Header), TLS: &tls.ConnectionState{}}}
+	actual := req.Scheme()
+	expect := "https"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`Request.Scheme() with TLS => %#v; want %#v`, actual, expect)
+	}
 }
 
 func TestRequest_IsSSL(t *testing.T) {
-	req := &Request{Request: &http.Request{Header: make(http.Header)}}
+	trustedProxies, err := parseTrustedProxies([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newRequest(&http.Request{Header: make(http.Header), RemoteAddr: "127.0.0.1:12345"}, trustedProxies)
 	actual := req.IsSSL()
 	expected := false
 	if !reflect.DeepEqual(actual, expected) {
@@ -65,6 +107,31 @@ func TestRequest_IsSSL(t *testing.T) {
 	}
 }
 
+func TestParseTrustedProxies(t *testing.T) {
+	for _, v := range []struct {
+		proxies []string
+		expect  []*net.IPNet
+		err     error
+	}{
+		{nil, nil, nil},
+		{[]string{"127.0.0.1", "10.0.0.0/8", "::1"}, []*net.IPNet{
+			{IP: net.IP{127, 0, 0, 1}, Mask: net.CIDRMask(32, 32)},
+			{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+			{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+		}, nil},
+		{[]string{"localhost"}, nil, fmt.Errorf(`kocha: invalid trusted proxy: "localhost"`)},
+		{[]string{"10.0.0.0/33"}, nil, fmt.Errorf(`kocha: invalid trusted proxy: "10.0.0.0/33"`)},
+	} {
+		actual, err := parseTrustedProxies(v.proxies)
+		if !reflect.DeepEqual(err, v.err) {
+			t.Errorf(`parseTrustedProxies(%#v) => _, %#v; want _, %#v`, v.proxies, err, v.err)
+		}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf(`parseTrustedProxies(%#v) => %#v, _; want %#v, _`, v.proxies, actual, v.expect)
+		}
+	}
+}
+
 func TestRequest_IsXHR(t *testing.T) {
 	r, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
@@ -84,3 +151,22 @@ func TestRequest_IsXHR(t *testing.T) {
 		t.Errorf(`Request.IsXHR() with "X-Requested-With: XMLHttpRequest" header => %#v; want %#v`, actual, expect)
 	}
 }
+
+func TestParseAccept(t *testing.T) {
+	for _, v := range []struct {
+		header string
+		expect []acceptSpec
+	}{
+		{"", nil},
+		{"gzip", []acceptSpec{{"gzip", 1}}},
+		{"gzip, deflate", []acceptSpec{{"gzip", 1}, {"deflate", 1}}},
+		{"deflate;q=0.5, GZIP", []acceptSpec{{"gzip", 1}, {"deflate", 0.5}}},
+		{"text/html;level=1;q=0.3, application/json, */*;q=invalid", []acceptSpec{{"application/json", 1}, {"text/html", 0.3}, {"*/*", 0}}},
+	} {
+		actual := parseAccept(v.header)
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`parseAccept(%#v) => %#v; want %#v`, v.header, actual, expect)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package kocha
 
 import (
+	"bufio"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -24,16 +27,20 @@ type Response struct {
 	ContentType string
 	StatusCode  int
 
-	cookies []*http.Cookie
-	resp    *httptest.ResponseRecorder
+	cookies   []*http.Cookie
+	resp      *httptest.ResponseRecorder
+	w         http.ResponseWriter // ResponseWriter of the client.
+	streaming bool
 }
 
-// newResponse returns a new Response that responds to rw.
-func newResponse() *Response {
+// newResponse returns a new Response that responds to w.
+func newResponse(w http.ResponseWriter) *Response {
 	r := responsePool.Get().(*Response)
 	r.reset()
 	r.ContentType = ""
 	r.cookies = r.cookies[:0]
+	r.w = w
+	r.streaming = false
 	return r
 }
 
@@ -48,7 +55,57 @@ func (r *Response) SetCookie(cookie *http.Cookie) {
 	http.SetCookie(r, cookie)
 }
 
+// IsStreaming returns whether the response is being streamed by
+// Context.Stream, or the connection has been hijacked such as the WebSocket.
+// If true, the response has already been sent to the client without the
+// buffering, so the middlewares can't modify the headers and the body.
+func (r *Response) IsStreaming() bool {
+	return r.streaming
+}
+
+// stream starts the streaming of the response.
+// It sends the headers that have been set and returns the writer to the client.
+func (r *Response) stream() *streamWriter {
+	w := r.w
+	if w == nil {
+		w = r.ResponseWriter
+	}
+	for key, values := range r.Header() {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(r.StatusCode)
+	r.streaming = true
+	sw := &streamWriter{w: w}
+	sw.Flush()
+	return sw
+}
+
+// hijack hijacks the connection of the client.
+func (r *Response) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w := r.w
+	if w == nil {
+		w = r.ResponseWriter
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("kocha: ResponseWriter doesn't support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.streaming = true
+	return conn, rw, nil
+}
+
 func (r *Response) writeTo(w http.ResponseWriter) error {
+	if r.streaming {
+		responsePool.Put(r)
+		return nil
+	}
 	for key, values := range r.Header() {
 		for _, v := range values {
 			w.Header().Add(key, v)
@@ -65,3 +122,20 @@ func (r *Response) reset() {
 	r.resp = httptest.NewRecorder()
 	r.ResponseWriter = r.resp
 }
+
+// streamWriter is the writer of the streaming response.
+type streamWriter struct {
+	w http.ResponseWriter
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	return sw.w.Write(p)
+}
+
+// Flush implements the http.Flusher.Flush.
+// It sends the buffered data to the client if the ResponseWriter supports it.
+func (sw *streamWriter) Flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
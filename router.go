@@ -2,9 +2,14 @@ package kocha
 
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"reflect"
-
+	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/naoina/denco"
 	"github.com/woremacx/kocha/util"
@@ -27,26 +32,112 @@ func (rt RouteTable) buildRouter() (*Router, error) {
 // Router represents a router of kocha.
 type Router struct {
 	forward    *denco.Router
+	hosts      []*hostRouter
 	reverse    map[string]*Route
 	routeTable RouteTable
+
+	name   string    // name of the application.
+	prefix string    // mount prefix of the application.
+	parent *Router   // router of the parent application.
+	mounts []*Router // routers of the mounted applications.
 }
 
-func (router *Router) dispatch(req *Request) (name string, handler requestHandler, params denco.Params, found bool) {
+func (router *Router) dispatch(req *Request) (route *Route, handler requestHandler, params denco.Params, found bool) {
 	path := util.NormPath(req.URL.Path)
-	data, params, found := router.forward.Lookup(path)
+	var data interface{}
+	if len(router.hosts) > 0 {
+		host, port := strings.ToLower(req.Host), ""
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			host, port = h, p
+		}
+		for _, hr := range router.hosts {
+			hostParams, matched := hr.match(host, port)
+			if !matched {
+				continue
+			}
+			if data, params, found = hr.forward.Lookup(path); found {
+				params = append(hostParams, params...)
+				break
+			}
+		}
+	}
 	if !found {
-		return "", nil, nil, false
+		if data, params, found = router.forward.Lookup(path); !found {
+			return nil, nil, nil, false
+		}
+	}
+	route = data.(*Route)
+	for _, param := range params {
+		if re := route.constraints[param.Name]; re != nil && !re.MatchString(param.Value) {
+			return nil, nil, nil, false
+		}
 	}
-	route := data.(*Route)
-	handler, found = route.dispatch(req.Method)
-	return route.Name, handler, params, found
+	return route, route.dispatch(req), params, true
+}
+
+// routeMatch represents the result of the routing for a request.
+type routeMatch struct {
+	path    string // URL path that is routed.
+	route   *Route
+	handler requestHandler
+	params  denco.Params
+	found   bool
 }
 
 // buildForward builds forward router.
 func (router *Router) buildForward() error {
-	records := make([]denco.Record, len(router.routeTable))
-	for i, route := range router.routeTable {
-		records[i] = denco.NewRecord(route.Path, route)
+	var records []denco.Record
+	hostRecords := make(map[*hostRouter][]denco.Record)
+	hosts := make(map[string]*hostRouter)
+	for _, route := range router.routeTable {
+		path, host, middlewares := route.Path, route.Host, route.Middlewares
+		if route.Group != nil {
+			path = route.Group.prefix() + route.Path
+			if host == "" {
+				host = route.Group.host()
+			}
+			middlewares = append(append([]Middleware{}, route.Group.middlewares()...), route.Middlewares...)
+		}
+		if route.Controller != nil && route.Handler != nil {
+			return fmt.Errorf("kocha: route %v: Controller and Handler cannot be specified at the same time", route.Name)
+		}
+		if v, ok := route.Controller.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return err
+			}
+		}
+		route.scheme, route.host = "http", host
+		if i := strings.Index(host, "://"); i >= 0 {
+			route.scheme, route.host = host[:i], host[i+len("://"):]
+		}
+		route.pattern = route.host + path
+		route.host, route.port = splitHostPattern(route.host)
+		path, constraints, err := parseRoutePath(path)
+		if err != nil {
+			return fmt.Errorf("kocha: route %v: %v", route.Name, err)
+		}
+		route.path, route.constraints, route.middlewares = path, constraints, middlewares
+		route.buildHandlers()
+		record := denco.NewRecord(route.path, route)
+		if route.host == "" {
+			records = append(records, record)
+			continue
+		}
+		key := route.host + ":" + route.port
+		hr := hosts[key]
+		if hr == nil {
+			if hr, err = newHostRouter(route.host, route.port); err != nil {
+				return fmt.Errorf("kocha: route %v: %v", route.Name, err)
+			}
+			hosts[key] = hr
+			router.hosts = append(router.hosts, hr)
+		}
+		hostRecords[hr] = append(hostRecords[hr], record)
+	}
+	for _, hr := range router.hosts {
+		if err := hr.forward.Build(hostRecords[hr]); err != nil {
+			return err
+		}
 	}
 	router.forward = denco.New()
 	return router.forward.Build(records)
@@ -57,10 +148,19 @@ func (router *Router) buildReverse() error {
 	router.reverse = make(map[string]*Route)
 	for _, route := range router.routeTable {
 		router.reverse[route.Name] = route
-		for i := 0; i < len(route.Path); i++ {
-			if c := route.Path[i]; c == denco.ParamCharacter || c == denco.WildcardCharacter {
-				next := denco.NextSeparator(route.Path, i+1)
-				route.paramNames = append(route.paramNames, route.Path[i:next])
+		route.router = router
+		route.paramNames = nil
+		for i := 0; i < len(route.host); i++ {
+			if route.host[i] == denco.ParamCharacter {
+				next := nextHostSeparator(route.host, i+1)
+				route.paramNames = append(route.paramNames, route.host[i:next])
+				i = next
+			}
+		}
+		for i := 0; i < len(route.path); i++ {
+			if c := route.path[i]; c == denco.ParamCharacter || c == denco.WildcardCharacter {
+				next := denco.NextSeparator(route.path, i+1)
+				route.paramNames = append(route.paramNames, route.path[i:next])
 				i = next
 			}
 		}
@@ -69,8 +169,13 @@ func (router *Router) buildReverse() error {
 }
 
 // Reverse returns path of route by name and any params.
+// The params are escaped properly.
+// If the path parameter of the route has a constraint, the corresponding
+// param must satisfy it.
+// If the route is bound to a host, Reverse returns an absolute URL, and the
+// params of the host precede the params of the path.
 func (router *Router) Reverse(name string, v ...interface{}) (string, error) {
-	route := router.reverse[name]
+	route := router.lookupReverse(name)
 	if route == nil {
 		types := make([]string, len(v))
 		for i, value := range v {
@@ -81,43 +186,297 @@ func (router *Router) Reverse(name string, v ...interface{}) (string, error) {
 	return route.reverse(v...)
 }
 
+// ReverseWith returns path of route by name and the named params.
+// ReverseWith is similar to Reverse, but the values of the path parameters
+// are taken from params by the parameter name without the leading character.
+// e.g. "id" for ":id". The values are escaped properly.
+// The remaining params that aren't the path parameters are appended as the
+// query string.
+func (router *Router) ReverseWith(name string, params map[string]interface{}) (string, error) {
+	route := router.lookupReverse(name)
+	if route == nil {
+		return "", fmt.Errorf("kocha: no match route found: %v", name)
+	}
+	return route.reverseWith(params)
+}
+
+// lookupReverse returns the route by name.
+// The name that is qualified by the application name such as "admin:user"
+// refers to the route of the application that is mounted to, or mounts,
+// the application of router.
+func (router *Router) lookupReverse(name string) *Route {
+	if route := router.reverse[name]; route != nil {
+		return route
+	}
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		return nil
+	}
+	root := router
+	for root.parent != nil {
+		root = root.parent
+	}
+	if r := root.findApp(name[:i]); r != nil {
+		return r.lookupReverse(name[i+1:])
+	}
+	return nil
+}
+
+// findApp returns the router of the application by name from router and
+// its mounted applications.
+func (router *Router) findApp(name string) *Router {
+	if router.name == name {
+		return router
+	}
+	for _, m := range router.mounts {
+		if r := m.findApp(name); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// mountPrefix returns the full mount prefix of the application.
+func (router *Router) mountPrefix() string {
+	if router.parent == nil {
+		return router.prefix
+	}
+	return router.parent.mountPrefix() + router.prefix
+}
+
 // Route represents a route.
+//
+// Path can contain the constraints of the path parameters such as
+// "/users/:id<int>" or "/posts/:slug<[a-z0-9-]+>". The constraint is either
+// the name of RouteParamTypes or a regular expression. If the value of the
+// parameter doesn't match the constraint, the route doesn't match.
 type Route struct {
 	Name       string
 	Path       string
 	Controller Controller
 
-	paramNames []string
+	// Middlewares are the middlewares that are applied to the route only.
+	// They are processed after the global middlewares and the middlewares of
+	// Group, and right before the handler of Controller.
+	Middlewares []Middleware
+
+	// Host is the host pattern that the route is bound to.
+	// e.g. "api.example.com", ":tenant.example.com" or "localhost:8080".
+	// The host parameter such as ":tenant" matches a label of the host name
+	// and its value can be retrieved from Context.Params. Router.Reverse
+	// returns an error if the value isn't a single label that consists of
+	// letters, digits and hyphens.
+	// If the port is specified, the route matches the requests to the port only.
+	// The scheme that is used by Router.Reverse can be specified by
+	// the prefix such as "https://api.example.com". (default is "http")
+	// If Host is empty, the Host of Group is used. If both are empty, the
+	// route matches any host.
+	Host string
+
+	// Group is the group that the route belongs to.
+	// If Group is not nil, Path is treated as relative to the prefix of Group.
+	Group *RouteGroup
+
+	// Handler is the plain net/http handler that handles the route instead
+	// of Controller. It handles the requests of any HTTP method, and the
+	// request is passed with the original URL path. Use the wildcard parameter
+	// such as "/debug/pprof/*path" in order to pass all the requests under
+	// the prefix to Handler.
+	// The global middlewares, the middlewares of Group and Middlewares are
+	// still processed in front of Handler.
+	Handler http.Handler
+
+	// Timeout is the timeout of the requests to the route.
+	// It overrides Config.Timeout. If 0, Config.Timeout is used.
+	// When it's exceeded, Context.Context() is cancelled. See TimeoutMiddleware.
+	Timeout time.Duration
+
+	router      *Router
+	scheme      string
+	host        string
+	port        string
+	pattern     string
+	path        string
+	paramNames  []string
+	constraints map[string]*regexp.Regexp
+	middlewares []Middleware
+	methods     []string
+	handlers    map[string]requestHandler
+	webSocket   func(c *Context, conn *WebSocketConn) error
+	allow       string
 }
 
-func (route *Route) dispatch(method string) (handler requestHandler, found bool) {
-	switch strings.ToUpper(method) {
-	case "GET":
-		if h, ok := route.Controller.(Getter); ok {
-			return h.GET, true
+func (route *Route) dispatch(req *Request) requestHandler {
+	if route.Handler != nil {
+		return route.serveHTTP
+	}
+	if route.webSocket != nil && isWebSocketUpgrade(req) {
+		return route.serveWebSocket
+	}
+	if handler, found := route.handlers[strings.ToUpper(req.Method)]; found {
+		return handler
+	}
+	return route.methodNotAllowed
+}
+
+// buildHandlers builds the handlers of the HTTP methods that are implemented
+// by the controller. The methods that are promoted from the embedded
+// DefaultController aren't regarded as implemented.
+func (route *Route) buildHandlers() {
+	route.methods = nil
+	route.handlers = make(map[string]requestHandler)
+	route.webSocket = nil
+	if route.Handler != nil {
+		route.allow = ""
+		return
+	}
+	if ws, ok := route.Controller.(WebSocketer); ok {
+		route.webSocket = ws.WebSocket
+	}
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"} {
+		var handler requestHandler
+		switch method {
+		case "GET":
+			if h, ok := route.Controller.(Getter); ok {
+				handler = h.GET
+			}
+		case "HEAD":
+			if h, ok := route.Controller.(Header); ok {
+				handler = h.HEAD
+			}
+		case "POST":
+			if h, ok := route.Controller.(Poster); ok {
+				handler = h.POST
+			}
+		case "PUT":
+			if h, ok := route.Controller.(Putter); ok {
+				handler = h.PUT
+			}
+		case "PATCH":
+			if h, ok := route.Controller.(Patcher); ok {
+				handler = h.PATCH
+			}
+		case "DELETE":
+			if h, ok := route.Controller.(Deleter); ok {
+				handler = h.DELETE
+			}
+		case "OPTIONS":
+			if h, ok := route.Controller.(Optioner); ok {
+				handler = h.OPTIONS
+			}
 		}
-	case "POST":
-		if h, ok := route.Controller.(Poster); ok {
-			return h.POST, true
+		if handler == nil || isDefaultControllerMethod(reflect.TypeOf(route.Controller), method) {
+			continue
 		}
-	case "PUT":
-		if h, ok := route.Controller.(Putter); ok {
-			return h.PUT, true
+		route.methods = append(route.methods, method)
+		route.handlers[method] = handler
+	}
+	if _, found := route.handlers["OPTIONS"]; !found {
+		route.handlers["OPTIONS"] = route.options
+	}
+	route.allow = buildAllow(route.methods)
+}
+
+var defaultControllerType = reflect.TypeOf(DefaultController{})
+
+// isDefaultControllerMethod returns whether the method of the name that t has
+// is promoted from the embedded DefaultController.
+func isDefaultControllerMethod(t reflect.Type, name string) bool {
+	owner, _ := methodOwner(t, name)
+	return owner == defaultControllerType
+}
+
+// methodOwner returns the type that declares the method of the name that t
+// or *t has, and the depth of the embedded field that the method is promoted
+// from. It follows the embedding path in the same way as the selector of Go,
+// that is, the method of the shallowest embedded field is selected.
+func methodOwner(t reflect.Type, name string) (reflect.Type, int) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	found := false
+	// the method of *t that has the value receiver is also a wrapper, so
+	// the method set of t is checked at first.
+	for _, typ := range []reflect.Type{t, reflect.PtrTo(t)} {
+		if m, ok := typ.MethodByName(name); ok {
+			if !isPromotedMethod(m) {
+				return t, 0
+			}
+			found = true
 		}
-	case "DELETE":
-		if h, ok := route.Controller.(Deleter); ok {
-			return h.DELETE, true
+	}
+	if !found {
+		return nil, -1
+	}
+	if t.Kind() != reflect.Struct {
+		return t, 0
+	}
+	var owner reflect.Type
+	depth := -1
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
 		}
-	case "HEAD":
-		if h, ok := route.Controller.(Header); ok {
-			return h.HEAD, true
+		if o, d := methodOwner(f.Type, name); o != nil && (depth < 0 || d+1 < depth) {
+			owner, depth = o, d+1
 		}
-	case "PATCH":
-		if h, ok := route.Controller.(Patcher); ok {
-			return h.PATCH, true
+	}
+	return owner, depth
+}
+
+// isPromotedMethod returns whether m is the wrapper method that the compiler
+// generates for the method promoted from an embedded field.
+func isPromotedMethod(m reflect.Method) bool {
+	f := runtime.FuncForPC(m.Func.Pointer())
+	if f == nil {
+		return false
+	}
+	file, _ := f.FileLine(f.Entry())
+	return file == "<autogenerated>"
+}
+
+// buildAllow returns the value of the Allow header from the methods.
+func buildAllow(methods []string) string {
+	for _, m := range methods {
+		if m == "OPTIONS" {
+			return strings.Join(methods, ", ")
 		}
 	}
-	return nil, false
+	return strings.Join(append(methods[:len(methods):len(methods)], "OPTIONS"), ", ")
+}
+
+// serveHTTP passes the request to Handler.
+func (route *Route) serveHTTP(c *Context) error {
+	route.Handler.ServeHTTP(c.Response, c.Request.Request)
+	c.Response.StatusCode = c.Response.resp.Code
+	return nil
+}
+
+// methodNotAllowed renders the HTTP 405 Method Not Allowed with the Allow header.
+func (route *Route) methodNotAllowed(c *Context) error {
+	c.Response.Header().Set("Allow", route.allow)
+	return c.RenderError(http.StatusMethodNotAllowed, nil, nil)
+}
+
+// options responds to the HTTP OPTIONS request with the Allow header.
+func (route *Route) options(c *Context) error {
+	c.Response.Header().Set("Allow", route.allow)
+	return nil
+}
+
+// Methods returns the HTTP methods that are implemented by the controller.
+// The methods that are promoted from the embedded DefaultController aren't
+// included. If the route is handled by Handler, it returns nil.
+func (route *Route) Methods() []string {
+	return route.methods
+}
+
+// Pattern returns the full pattern of the route.
+// The pattern consists of the host pattern and the path that is joined with
+// the prefixes of the groups. e.g. ":tenant.example.com/admin/users/:id<int>"
+func (route *Route) Pattern() string {
+	return route.pattern
 }
 
 // ParamNames returns names of the path parameters.
@@ -132,13 +491,309 @@ func (r *Route) reverse(v ...interface{}) (string, error) {
 	case vlen > nlen:
 		return "", fmt.Errorf("kocha: too many arguments: %v (controller is %T)", r.Name, r.Controller)
 	case vlen+nlen == 0:
-		return r.Path, nil
+		return r.absolute(r.host, r.path), nil
 	}
-	var oldnew []string
+	values := make(map[string]string, len(v))
 	for i := 0; i < len(v); i++ {
-		oldnew = append(oldnew, r.paramNames[i], fmt.Sprint(v[i]))
+		values[r.paramNames[i]] = fmt.Sprint(v[i])
+	}
+	return r.build(values)
+}
+
+func (r *Route) reverseWith(params map[string]interface{}) (string, error) {
+	values := make(map[string]string, len(r.paramNames))
+	for _, name := range r.paramNames {
+		v, found := params[name[1:]]
+		if !found {
+			return "", fmt.Errorf("kocha: missing argument: %v: %v (controller is %T)", r.Name, name, r.Controller)
+		}
+		values[name] = fmt.Sprint(v)
+	}
+	u, err := r.build(values)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	for k, v := range params {
+		if _, found := values[string(denco.ParamCharacter)+k]; found {
+			continue
+		}
+		if _, found := values[string(denco.WildcardCharacter)+k]; found {
+			continue
+		}
+		switch vs := v.(type) {
+		case []string:
+			query[k] = append(query[k], vs...)
+		case []interface{}:
+			for _, v := range vs {
+				query.Add(k, fmt.Sprint(v))
+			}
+		default:
+			query.Add(k, fmt.Sprint(v))
+		}
+	}
+	if len(query) == 0 {
+		return u, nil
+	}
+	return u + "?" + query.Encode(), nil
+}
+
+// build builds the URL of the route by replacing the parameters with values.
+// The key of values is the parameter name that includes the leading
+// character such as ":id".
+func (r *Route) build(values map[string]string) (string, error) {
+	for name, value := range values {
+		if re := r.constraints[name[1:]]; re != nil && !re.MatchString(value) {
+			return "", fmt.Errorf("kocha: invalid argument: %v: %v doesn't match %v (controller is %T)", r.Name, value, name, r.Controller)
+		}
+	}
+	host := make([]byte, 0, len(r.host))
+	for i := 0; i < len(r.host); i++ {
+		if r.host[i] != denco.ParamCharacter {
+			host = append(host, r.host[i])
+			continue
+		}
+		next := nextHostSeparator(r.host, i+1)
+		name := r.host[i:next]
+		value := values[name]
+		if !hostLabelRegexp.MatchString(value) {
+			return "", fmt.Errorf("kocha: invalid argument: %v: %q isn't a valid host label for %v (controller is %T)", r.Name, value, name, r.Controller)
+		}
+		host = append(host, value...)
+		i = next - 1
+	}
+	path := make([]byte, 0, len(r.path))
+	for i := 0; i < len(r.path); i++ {
+		c := r.path[i]
+		if c != denco.ParamCharacter && c != denco.WildcardCharacter {
+			path = append(path, c)
+			continue
+		}
+		next := denco.NextSeparator(r.path, i+1)
+		value := values[r.path[i:next]]
+		if c == denco.WildcardCharacter {
+			segments := strings.Split(value, "/")
+			for j, segment := range segments {
+				segments[j] = url.PathEscape(segment)
+			}
+			value = strings.Join(segments, "/")
+		} else {
+			value = url.PathEscape(value)
+		}
+		path = append(path, value...)
+		i = next - 1
+	}
+	return r.absolute(string(host), util.NormPath(string(path))), nil
+}
+
+// absolute returns the absolute URL of the path if the route is bound to a host.
+// If the application is mounted, the mount prefix is added to the path.
+func (r *Route) absolute(host, path string) string {
+	if r.router != nil {
+		if prefix := r.router.mountPrefix(); prefix != "" {
+			path = prefix + path
+		}
+	}
+	if host == "" {
+		return path
+	}
+	if r.port != "" {
+		host += ":" + r.port
+	}
+	return r.scheme + "://" + host + path
+}
+
+// RouteParamTypes is the named constraints for the path parameters.
+// The key is the type name, and the value is the regular expression that
+// the value of the parameter must match.
+// e.g. "/users/:id<int>" is the same as "/users/:id<-?[0-9]+>".
+var RouteParamTypes = map[string]string{
+	"int":   `-?[0-9]+`,
+	"uint":  `[0-9]+`,
+	"alpha": `[A-Za-z]+`,
+	"alnum": `[A-Za-z0-9]+`,
+	"hex":   `[0-9A-Fa-f]+`,
+	"uuid":  `[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}`,
+}
+
+// parseRoutePath parses the constraints of the path parameters such as
+// "/users/:id<int>" or "/posts/:slug<[a-z0-9-]+>".
+// It returns the path that the constraints are removed, and the map of
+// the parameter name to the compiled constraint.
+func parseRoutePath(path string) (string, map[string]*regexp.Regexp, error) {
+	if !strings.ContainsRune(path, '<') {
+		return path, nil, nil
+	}
+	buf := make([]byte, 0, len(path))
+	constraints := make(map[string]*regexp.Regexp)
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c != denco.ParamCharacter && c != denco.WildcardCharacter {
+			buf = append(buf, c)
+			continue
+		}
+		end := i + 1
+		for end < len(path) && path[end] != '<' && path[end] != '/' {
+			end++
+		}
+		name := path[i+1 : end]
+		buf = append(buf, path[i:end]...)
+		if end >= len(path) || path[end] != '<' {
+			i = end - 1
+			continue
+		}
+		start, depth := end+1, 1
+		for end = start; end < len(path); end++ {
+			if path[end] == '<' {
+				depth++
+			} else if path[end] == '>' {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		if end >= len(path) {
+			return "", nil, fmt.Errorf("unclosed constraint of parameter `%v'", name)
+		}
+		expr := path[start:end]
+		if t, found := RouteParamTypes[expr]; found {
+			expr = t
+		}
+		re, err := regexp.Compile(`\A(?:` + expr + `)\z`)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid constraint of parameter `%v': %v", name, err)
+		}
+		constraints[name] = re
+		i = end
+	}
+	return string(buf), constraints, nil
+}
+
+// RouteGroup represents a group of routes that share a path prefix and middlewares.
+//
+// The routes belong to the group by setting the group to Route.Group.
+// Also the group can be nested by setting the parent group to Parent.
+type RouteGroup struct {
+	// Prefix is the path prefix of the routes in the group.
+	// e.g. "/admin" or "/api/v1".
+	Prefix string
+
+	// Middlewares are the middlewares that are applied to the routes in the
+	// group only. They are processed after the global middlewares (i.e.
+	// Config.Middlewares) and before the handler of the route.
+	Middlewares []Middleware
+
+	// Host is the host pattern that the routes in the group are bound to.
+	// See Route.Host for details. If Host is empty, the Host of Parent is used.
+	Host string
+
+	// Parent is the parent group of the group.
+	Parent *RouteGroup
+}
+
+func (g *RouteGroup) prefix() string {
+	prefix := strings.TrimSuffix(g.Prefix, "/")
+	if g.Parent != nil {
+		prefix = g.Parent.prefix() + prefix
+	}
+	return prefix
+}
+
+func (g *RouteGroup) host() string {
+	if g.Host == "" && g.Parent != nil {
+		return g.Parent.host()
+	}
+	return g.Host
+}
+
+func (g *RouteGroup) middlewares() []Middleware {
+	if g.Parent == nil {
+		return g.Middlewares
+	}
+	return append(append([]Middleware{}, g.Parent.middlewares()...), g.Middlewares...)
+}
+
+// hostLabelRegexp is the regular expression that the value of the host
+// parameter must match. The value must be a single label of the host name.
+var hostLabelRegexp = regexp.MustCompile(`\A[A-Za-z0-9-]+\z`)
+
+// hostRouter represents a router for the routes that are bound to a host pattern.
+type hostRouter struct {
+	re         *regexp.Regexp
+	port       string
+	paramNames []string
+	forward    *denco.Router
+}
+
+// newHostRouter returns a new hostRouter for the host pattern such as
+// ":tenant.example.com". If port isn't empty, the host matches only if the
+// port is the same.
+func newHostRouter(pattern, port string) (*hostRouter, error) {
+	hr := &hostRouter{port: port, forward: denco.New()}
+	expr := make([]byte, 0, len(pattern)*2)
+	expr = append(expr, `\A`...)
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != denco.ParamCharacter {
+			next := strings.IndexByte(pattern[i:], denco.ParamCharacter)
+			if next < 0 {
+				next = len(pattern) - i
+			}
+			expr = append(expr, regexp.QuoteMeta(strings.ToLower(pattern[i:i+next]))...)
+			i += next - 1
+			continue
+		}
+		next := nextHostSeparator(pattern, i+1)
+		if next == i+1 {
+			return nil, fmt.Errorf("empty parameter name in host `%v'", pattern)
+		}
+		hr.paramNames = append(hr.paramNames, pattern[i+1:next])
+		expr = append(expr, `([^.]+)`...)
+		i = next - 1
+	}
+	expr = append(expr, `\z`...)
+	re, err := regexp.Compile(string(expr))
+	if err != nil {
+		return nil, err
+	}
+	hr.re = re
+	return hr, nil
+}
+
+// match returns the host parameters and whether the host matches the pattern.
+func (hr *hostRouter) match(host, port string) (params denco.Params, matched bool) {
+	if hr.port != "" && hr.port != port {
+		return nil, false
+	}
+	m := hr.re.FindStringSubmatch(host)
+	if m == nil {
+		return nil, false
+	}
+	for i, name := range hr.paramNames {
+		params = append(params, denco.Param{Name: name, Value: m[i+1]})
+	}
+	return params, true
+}
+
+// splitHostPattern splits the host pattern into the host and the port.
+// e.g. ":tenant.example.com:8080" => ":tenant.example.com", "8080"
+// The colon that follows the separator of the labels is regarded as the
+// beginning of the parameter, not the port.
+func splitHostPattern(pattern string) (host, port string) {
+	i := strings.LastIndexByte(pattern, ':')
+	if i <= 0 || i == len(pattern)-1 || pattern[i-1] == '.' {
+		return pattern, ""
+	}
+	for _, c := range pattern[i+1:] {
+		if c < '0' || c > '9' {
+			return pattern, ""
+		}
+	}
+	return pattern[:i], pattern[i+1:]
+}
+
+func nextHostSeparator(host string, start int) int {
+	for start < len(host) && host[start] != '.' {
+		start++
 	}
-	replacer := strings.NewReplacer(oldnew...)
-	path := replacer.Replace(r.Path)
-	return util.NormPath(path), nil
+	return start
 }
@@ -2,6 +2,9 @@ package kocha_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
@@ -67,3 +70,345 @@ func TestRouter_Reverse_withManyArguments(t *testing.T) {
 		t.Errorf(`Router.Reverse(%#v, %#v) => (_, %#v); want (_, %#v)`, name, args, actual, expect)
 	}
 }
+
+func TestRouter_Reverse_withRouteGroup(t *testing.T) {
+	admin := &kocha.RouteGroup{Prefix: "/admin/"}
+	api := &kocha.RouteGroup{Prefix: "/api/:version", Parent: admin}
+	config := &kocha.Config{
+		RouteTable: kocha.RouteTable{
+			{Name: "root", Path: "/", Controller: &kocha.FixtureRootTestCtrl{}},
+			{Name: "admin_root", Path: "", Controller: &kocha.FixtureRootTestCtrl{}, Group: admin},
+			{Name: "admin_user", Path: "/user/:id", Controller: &kocha.FixtureUserTestCtrl{}, Group: admin},
+			{Name: "api_user", Path: "/user/:id", Controller: &kocha.FixtureUserTestCtrl{}, Group: api},
+		},
+		Template: &kocha.Template{},
+		Logger:   &kocha.LoggerConfig{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		name   string
+		args   []interface{}
+		expect string
+	}{
+		{"root", []interface{}{}, "/"},
+		{"admin_root", []interface{}{}, "/admin"},
+		{"admin_user", []interface{}{77}, "/admin/user/77"},
+		{"api_user", []interface{}{1, 77}, "/admin/api/1/user/77"},
+	} {
+		actual, err := app.Router.Reverse(v.name, v.args...)
+		if err != nil {
+			t.Errorf(`Router.Reverse(%#v, %#v) => (_, %#v); want (_, %#v)`, v.name, v.args, err, nil)
+			continue
+		}
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`Router.Reverse(%#v, %#v) => (%#v, %#v); want (%#v, %#v)`, v.name, v.args, actual, err, expect, nil)
+		}
+	}
+}
+
+type testOptionsCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testOptionsCtrl) PUT(c *kocha.Context) error {
+	return c.RenderText("PUT")
+}
+
+func (ctrl *testOptionsCtrl) OPTIONS(c *kocha.Context) error {
+	return c.RenderText("OPTIONS")
+}
+
+type testAllMethodsCtrl struct {
+	*testOptionsCtrl
+}
+
+func (ctrl *testAllMethodsCtrl) GET(c *kocha.Context) error    { return nil }
+func (ctrl *testAllMethodsCtrl) POST(c *kocha.Context) error   { return nil }
+func (ctrl *testAllMethodsCtrl) DELETE(c *kocha.Context) error { return nil }
+func (ctrl *testAllMethodsCtrl) HEAD(c *kocha.Context) error   { return nil }
+func (ctrl *testAllMethodsCtrl) PATCH(c *kocha.Context) error  { return nil }
+
+type testFallbackCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testFallbackCtrl) GET(c *kocha.Context) error {
+	if c.Request.URL.Query().Get("fallback") != "" {
+		return ctrl.DefaultController.GET(c)
+	}
+	return c.RenderText("GET")
+}
+
+func TestRoute_Methods(t *testing.T) {
+	for _, v := range []struct {
+		controller kocha.Controller
+		expect     []string
+	}{
+		{&kocha.FixtureRootTestCtrl{}, []string{"GET"}},
+		{&kocha.FixtureDateTestCtrl{}, []string{"GET"}},
+		{&kocha.FixturePostTestCtrl{}, []string{"POST"}},
+		{&kocha.StaticServe{}, []string{"GET"}},
+		{&testOptionsCtrl{}, []string{"PUT", "OPTIONS"}},
+		{&testAllMethodsCtrl{}, []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}},
+		{&testFallbackCtrl{}, []string{"GET"}},
+		{&kocha.DefaultController{}, nil},
+	} {
+		config := &kocha.Config{
+			RouteTable: kocha.RouteTable{{Name: "test", Path: "/", Controller: v.controller}},
+			Template:   &kocha.Template{},
+			Logger:     &kocha.LoggerConfig{},
+		}
+		app, err := kocha.New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := app.Config.RouteTable[0].Methods()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`Route{Controller: %T}.Methods() => %#v; want %#v`, v.controller, actual, expect)
+		}
+	}
+}
+
+func TestRouter_withParamConstraints(t *testing.T) {
+	config := &kocha.Config{
+		RouteTable: kocha.RouteTable{
+			{Name: "user", Path: "/users/:id<int>", Controller: &testRouteNameCtrl{}},
+			{Name: "post", Path: "/posts/:slug<[a-z0-9-]+>/:page<uint>", Controller: &testRouteNameCtrl{}},
+			{Name: "file", Path: "/files/*path<[^.]+\\.txt>", Controller: &testRouteNameCtrl{}},
+		},
+		Middlewares: []kocha.Middleware{&kocha.DispatchMiddleware{}},
+		Template:    &kocha.Template{},
+		Logger:      &kocha.LoggerConfig{Writer: ioutil.Discard},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		uri    string
+		status int
+	}{
+		{"/users/1", http.StatusOK},
+		{"/users/-1", http.StatusOK},
+		{"/users/a", http.StatusNotFound},
+		{"/posts/hello-world/2", http.StatusOK},
+		{"/posts/Hello/2", http.StatusNotFound},
+		{"/posts/hello/-2", http.StatusNotFound},
+		{"/files/a/b.txt", http.StatusOK},
+		{"/files/a/b.png", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		actual := w.Code
+		expect := v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v status => %#v; want %#v`, v.uri, actual, expect)
+		}
+	}
+
+	for _, v := range []struct {
+		name   string
+		args   []interface{}
+		expect string
+		err    error
+	}{
+		{"user", []interface{}{77}, "/users/77", nil},
+		{"user", []interface{}{"a"}, "", fmt.Errorf("kocha: invalid argument: user: a doesn't match :id (controller is %T)", &testRouteNameCtrl{})},
+		{"post", []interface{}{"hello-world", 2}, "/posts/hello-world/2", nil},
+		{"post", []interface{}{"Hello", 2}, "", fmt.Errorf("kocha: invalid argument: post: Hello doesn't match :slug (controller is %T)", &testRouteNameCtrl{})},
+		{"file", []interface{}{"a/b.txt"}, "/files/a/b.txt", nil},
+	} {
+		actual, err := app.Router.Reverse(v.name, v.args...)
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) || !reflect.DeepEqual(err, v.err) {
+			t.Errorf(`Router.Reverse(%#v, %#v) => (%#v, %#v); want (%#v, %#v)`, v.name, v.args, actual, err, expect, v.err)
+		}
+	}
+
+	config.RouteTable = kocha.RouteTable{
+		{Name: "user", Path: "/users/:id<[0-9]+", Controller: &testRouteNameCtrl{}},
+	}
+	_, err = kocha.New(config)
+	var actual interface{} = err
+	var expect interface{} = fmt.Errorf("kocha: route user: unclosed constraint of parameter `id'")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`New(config) => (_, %#v); want (_, %#v)`, actual, expect)
+	}
+}
+
+func TestRouter_withHost(t *testing.T) {
+	tenant := &kocha.RouteGroup{Host: "https://:tenant.example.org"}
+	config := &kocha.Config{
+		RouteTable: kocha.RouteTable{
+			{Name: "root", Path: "/", Controller: &testRouteNameCtrl{}},
+			{Name: "api_root", Path: "/", Controller: &testRouteNameCtrl{}, Host: "api.example.com"},
+			{Name: "api_item", Path: "/items/:id", Controller: &testRouteNameCtrl{}, Host: "api.example.com"},
+			{Name: "tenant_root", Path: "/", Controller: &testHostParamCtrl{}, Group: tenant},
+			{Name: "tenant_item", Path: "/items/:id", Controller: &testHostParamCtrl{}, Group: tenant},
+			{Name: "dev_root", Path: "/", Controller: &testHostParamCtrl{}, Host: ":tenant.localhost:8080"},
+		},
+		Middlewares: []kocha.Middleware{&kocha.DispatchMiddleware{}},
+		Template:    &kocha.Template{},
+		Logger:      &kocha.LoggerConfig{Writer: ioutil.Discard},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		host   string
+		uri    string
+		status int
+		body   string
+	}{
+		{"www.example.com", "/", http.StatusOK, "root"},
+		{"api.example.com", "/", http.StatusOK, "api_root"},
+		{"API.example.com:9100", "/", http.StatusOK, "api_root"},
+		{"api.example.com", "/items/1", http.StatusOK, "api_item:1"},
+		{"www.example.com", "/items/1", http.StatusNotFound, "Not Found"},
+		{"acme.example.org", "/", http.StatusOK, "tenant_root:acme:"},
+		{"acme.example.org", "/items/2", http.StatusOK, "tenant_item:acme:2"},
+		{"a.b.example.org", "/", http.StatusOK, "root"},
+		{"acme.localhost:8080", "/", http.StatusOK, "dev_root:acme:"},
+		{"acme.localhost:8081", "/", http.StatusOK, "root"},
+		{"acme.localhost", "/", http.StatusOK, "root"},
+	} {
+		req, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = v.host
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v (Host: %#v) status => %#v; want %#v`, v.uri, v.host, actual, expect)
+		}
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`GET %#v (Host: %#v) => %#v; want %#v`, v.uri, v.host, actual, expect)
+		}
+	}
+
+	for _, v := range []struct {
+		name   string
+		args   []interface{}
+		expect string
+	}{
+		{"root", []interface{}{}, "/"},
+		{"api_root", []interface{}{}, "http://api.example.com/"},
+		{"api_item", []interface{}{1}, "http://api.example.com/items/1"},
+		{"tenant_root", []interface{}{"acme"}, "https://acme.example.org/"},
+		{"tenant_item", []interface{}{"acme", 2}, "https://acme.example.org/items/2"},
+		{"dev_root", []interface{}{"acme"}, "http://acme.localhost:8080/"},
+	} {
+		actual, err := app.Router.Reverse(v.name, v.args...)
+		if err != nil {
+			t.Errorf(`Router.Reverse(%#v, %#v) => (_, %#v); want (_, %#v)`, v.name, v.args, err, nil)
+			continue
+		}
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`Router.Reverse(%#v, %#v) => (%#v, %#v); want (%#v, %#v)`, v.name, v.args, actual, err, expect, nil)
+		}
+	}
+
+	for _, v := range []string{"evil.com/x", "evil.com", "evil:80", "user@evil", "a/b", ""} {
+		_, err := app.Router.Reverse("tenant_root", v)
+		expect := fmt.Errorf("kocha: invalid argument: tenant_root: %q isn't a valid host label for :tenant (controller is *kocha_test.testHostParamCtrl)", v)
+		if !reflect.DeepEqual(err, expect) {
+			t.Errorf(`Router.Reverse(%#v, %#v) => (_, %#v); want (_, %#v)`, "tenant_root", v, err, expect)
+		}
+	}
+}
+
+type testHostParamCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testHostParamCtrl) GET(c *kocha.Context) error {
+	return c.RenderText(c.Name + ":" + c.Params.Get("tenant") + ":" + c.Params.Get("id"))
+}
+
+func TestRoute_Pattern(t *testing.T) {
+	admin := &kocha.RouteGroup{Prefix: "/admin", Host: "https://:tenant.example.com"}
+	config := &kocha.Config{
+		RouteTable: kocha.RouteTable{
+			{Name: "root", Path: "/", Controller: &testRouteNameCtrl{}},
+			{Name: "user", Path: "/users/:id<int>", Controller: &testRouteNameCtrl{}, Group: admin},
+		},
+		Template: &kocha.Template{},
+		Logger:   &kocha.LoggerConfig{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expect := range []string{"/", ":tenant.example.com/admin/users/:id<int>"} {
+		route := app.Config.RouteTable[i]
+		actual := route.Pattern()
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`Route{Name: %#v}.Pattern() => %#v; want %#v`, route.Name, actual, expect)
+		}
+	}
+}
+
+func TestRouter_ReverseWith(t *testing.T) {
+	config := &kocha.Config{
+		RouteTable: kocha.RouteTable{
+			{Name: "root", Path: "/", Controller: &testRouteNameCtrl{}},
+			{Name: "item", Path: "/:id/:idx", Controller: &testRouteNameCtrl{}},
+			{Name: "user", Path: "/users/:id<int>", Controller: &testRouteNameCtrl{}},
+			{Name: "static", Path: "/static/*path", Controller: &testRouteNameCtrl{}},
+			{Name: "tenant", Path: "/:page", Controller: &testRouteNameCtrl{}, Host: ":tenant.example.com"},
+		},
+		Template: &kocha.Template{},
+		Logger:   &kocha.LoggerConfig{},
+	}
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		name   string
+		params map[string]interface{}
+		expect string
+		err    error
+	}{
+		{"root", nil, "/", nil},
+		{"root", map[string]interface{}{"q": "a b", "page": 2}, "/?page=2&q=a+b", nil},
+		{"root", map[string]interface{}{"tag": []string{"b", "a"}}, "/?tag=b&tag=a", nil},
+		{"item", map[string]interface{}{"id": 1, "idx": 2}, "/1/2", nil},
+		{"item", map[string]interface{}{"id": "a/b", "idx": "c d"}, "/a%2Fb/c%20d", nil},
+		{"item", map[string]interface{}{"id": 1}, "", fmt.Errorf("kocha: missing argument: item: :idx (controller is %T)", &testRouteNameCtrl{})},
+		{"user", map[string]interface{}{"id": 1, "sort": "name"}, "/users/1?sort=name", nil},
+		{"user", map[string]interface{}{"id": "a"}, "", fmt.Errorf("kocha: invalid argument: user: a doesn't match :id (controller is %T)", &testRouteNameCtrl{})},
+		{"static", map[string]interface{}{"path": "css/a b.css"}, "/static/css/a%20b.css", nil},
+		{"tenant", map[string]interface{}{"tenant": "acme", "page": "about"}, "http://acme.example.com/about", nil},
+		{"unknown", nil, "", fmt.Errorf("kocha: no match route found: unknown")},
+	} {
+		actual, err := app.Router.ReverseWith(v.name, v.params)
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) || !reflect.DeepEqual(err, v.err) {
+			t.Errorf(`Router.ReverseWith(%#v, %#v) => (%#v, %#v); want (%#v, %#v)`, v.name, v.params, actual, err, expect, v.err)
+		}
+	}
+
+	// positional params are also replaced correctly even if a name is a prefix of another.
+	actual, err := app.Router.Reverse("item", 1, 2)
+	expect := "/1/2"
+	if !reflect.DeepEqual(actual, expect) || err != nil {
+		t.Errorf(`Router.Reverse(%#v, %#v, %#v) => (%#v, %#v); want (%#v, %#v)`, "item", 1, 2, actual, err, expect, nil)
+	}
+}
@@ -0,0 +1,145 @@
+package kocha
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEBufferSize is the default number of the events that are buffered
+// for each subscriber of SSEBroker.
+const DefaultSSEBufferSize = 16
+
+// SSEEvent represents an event of the Server-Sent Events.
+type SSEEvent struct {
+	// ID of the event. The client sends it back by Last-Event-ID header on
+	// reconnection. If empty, the "id" field isn't sent.
+	ID string
+
+	// Type of the event. If empty, the "event" field isn't sent and the
+	// client dispatches the event as "message".
+	Event string
+
+	// Data of the event. It's sent as the multiple "data" fields if it
+	// contains the newlines.
+	Data string
+
+	// Reconnection time of the client. If 0, the "retry" field isn't sent.
+	Retry time.Duration
+}
+
+// writeTo writes the event in the format of the event stream to w.
+func (e *SSEEvent) writeTo(w io.Writer) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return fmt.Errorf("kocha: sse: ID must not contain a newline or NULL, but %q", e.ID)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return fmt.Errorf("kocha: sse: Event must not contain a newline, but %q", e.Event)
+	}
+	var buf []byte
+	if e.ID != "" {
+		buf = append(append(append(buf, "id: "...), e.ID...), '\n')
+	}
+	if e.Event != "" {
+		buf = append(append(append(buf, "event: "...), e.Event...), '\n')
+	}
+	if e.Retry > 0 {
+		buf = append(strconv.AppendInt(append(buf, "retry: "...), int64(e.Retry/time.Millisecond), 10), '\n')
+	}
+	if e.Data != "" {
+		data := strings.Replace(strings.Replace(e.Data, "\r\n", "\n", -1), "\r", "\n", -1)
+		for _, line := range strings.Split(data, "\n") {
+			buf = append(append(append(buf, "data: "...), line...), '\n')
+		}
+	}
+	_, err := w.Write(append(buf, '\n'))
+	return err
+}
+
+// SSEBroker fans out the events to the subscribers such as the clients of
+// Context.SSE. It can be used with Event in order to send the events that
+// are triggered by Event.Trigger to the clients. e.g.
+//
+//	broker := kocha.NewSSEBroker()
+//	config.Event = &kocha.Event{
+//	    HandlerMap: kocha.EventHandlerMap{
+//	        &memory.EventQueue{}: {
+//	            "notify": func(app *kocha.Application, args ...interface{}) error {
+//	                broker.Publish(&kocha.SSEEvent{Event: "notify", Data: args[0].(string)})
+//	                return nil
+//	            },
+//	        },
+//	    },
+//	}
+//
+// and in the controller:
+//
+//	events, unsubscribe := broker.Subscribe()
+//	defer unsubscribe()
+//	return c.SSE(events)
+//
+// The zero value of SSEBroker is ready to use.
+type SSEBroker struct {
+	// Number of the events that are buffered for each subscriber.
+	// The events to the subscriber that has filled the buffer are dropped.
+	// Default is DefaultSSEBufferSize.
+	BufferSize int
+
+	subscribers map[chan *SSEEvent]struct{}
+	mu          sync.Mutex
+}
+
+// NewSSEBroker returns a new SSEBroker.
+func NewSSEBroker() *SSEBroker {
+	return &SSEBroker{
+		BufferSize: DefaultSSEBufferSize,
+	}
+}
+
+// Subscribe adds a new subscriber and returns the channel that receives the
+// published events. unsubscribe must be called when the subscriber has gone.
+func (b *SSEBroker) Subscribe() (events <-chan *SSEEvent, unsubscribe func()) {
+	size := b.BufferSize
+	if size < 1 {
+		size = DefaultSSEBufferSize
+	}
+	ch := make(chan *SSEEvent, size)
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan *SSEEvent]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the event to all the subscribers.
+// It doesn't block even if the subscriber is slow.
+func (b *SSEBroker) Publish(e *SSEEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Len returns the number of the subscribers.
+func (b *SSEBroker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
@@ -0,0 +1,111 @@
+package kocha_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/woremacx/kocha"
+)
+
+func TestContext_SSE(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	w := httptest.NewRecorder()
+	c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+	events := make(chan *kocha.SSEEvent, 3)
+	events <- &kocha.SSEEvent{Data: "hello"}
+	events <- &kocha.SSEEvent{ID: "2", Event: "notify", Data: "line1\nline2\r\nline3", Retry: 3 * time.Second}
+	events <- &kocha.SSEEvent{Retry: time.Second}
+	close(events)
+	if err := c.SSE(events); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = []interface{}{w.Code, w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"), w.Flushed}
+	var expect interface{} = []interface{}{http.StatusOK, "text/event-stream", "no-cache", true}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`Context.SSE(events) => %#v; want %#v`, actual, expect)
+	}
+	actual = w.Body.String()
+	expect = "data: hello\n\n" +
+		"id: 2\nevent: notify\nretry: 3000\ndata: line1\ndata: line2\ndata: line3\n\n" +
+		"retry: 1000\n\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`Context.SSE(events); body => %#v; want %#v`, actual, expect)
+	}
+
+	c = newTestContext("testctrlr", "")
+	c.Response = &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: http.StatusOK}
+	events = make(chan *kocha.SSEEvent, 1)
+	events <- &kocha.SSEEvent{ID: "a\nb"}
+	err := c.SSE(events)
+	suffix := fmt.Sprintf("kocha: sse: ID must not contain a newline or NULL, but %q", "a\nb")
+	if err == nil || !strings.HasSuffix(err.Error(), suffix) {
+		t.Errorf(`Context.SSE(events) with invalid ID => %#v; want error with suffix %#v`, err, suffix)
+	}
+}
+
+func TestContext_SSE_withDisconnect(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Request.Request = c.Request.WithContext(ctx)
+	c.Response = &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: http.StatusOK}
+	done := make(chan error)
+	go func() {
+		done <- c.SSE(make(chan *kocha.SSEEvent))
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf(`Context.SSE(events) after disconnect => %#v; want nil`, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf(`Context.SSE(events) hasn't returned after disconnect`)
+	}
+}
+
+func TestSSEBroker(t *testing.T) {
+	broker := kocha.NewSSEBroker()
+	broker.BufferSize = 2
+	events1, unsubscribe1 := broker.Subscribe()
+	events2, unsubscribe2 := broker.Subscribe()
+	var actual interface{} = broker.Len()
+	var expect interface{} = 2
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`SSEBroker.Len() => %#v; want %#v`, actual, expect)
+	}
+
+	for _, data := range []string{"1", "2", "3"} {
+		broker.Publish(&kocha.SSEEvent{Data: data})
+	}
+	unsubscribe1()
+	unsubscribe1()
+	broker.Publish(&kocha.SSEEvent{Data: "4"})
+	actual = broker.Len()
+	expect = 1
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`SSEBroker.Len() after unsubscribe => %#v; want %#v`, actual, expect)
+	}
+
+	var received []string
+	for e := range events1 {
+		received = append(received, e.Data)
+	}
+	actual = received
+	expect = []string{"1", "2"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`SSEBroker; received events => %#v; want %#v`, actual, expect)
+	}
+
+	actual = []string{(<-events2).Data, (<-events2).Data}
+	expect = []string{"1", "2"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`SSEBroker; received events => %#v; want %#v`, actual, expect)
+	}
+	unsubscribe2()
+}
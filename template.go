@@ -99,10 +99,14 @@ func (t *Template) buildFuncMap() (*Template, error) {
 		"yield":           t.yield,
 		"in":              t.in,
 		"url":             t.url,
+		"url_with":        t.urlWith,
 		"nl2br":           t.nl2br,
 		"raw":             t.raw,
 		"invoke_template": t.invokeTemplate,
 		"flash":           t.flash,
+		"csrf_token":      t.csrfToken,
+		"csrf_field":      t.csrfField,
+		"csp_nonce":       t.cspNonce,
 		"join":            t.join,
 	}
 	for name, fn := range t.FuncMap {
@@ -252,6 +256,24 @@ func (t *Template) url(name string, v ...interface{}) (string, error) {
 	return t.app.Router.Reverse(name, v...)
 }
 
+// urlWith is for "url_with" template function.
+// The arguments after the name are pairs of the parameter name and value.
+// e.g. {{url_with "user" "id" 1 "page" 2}}
+func (t *Template) urlWith(name string, kv ...interface{}) (string, error) {
+	if len(kv)%2 != 0 {
+		return "", fmt.Errorf("number of arguments after the name must be even")
+	}
+	params := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			return "", fmt.Errorf("parameter name must be string, got %T", kv[i])
+		}
+		params[key] = kv[i+1]
+	}
+	return t.app.Router.ReverseWith(name, params)
+}
+
 // nl2br is for "nl2br" template function.
 func (t *Template) nl2br(text string) template.HTML {
 	return template.HTML(strings.Replace(template.HTMLEscapeString(text), "\n", "<br>", -1))
@@ -289,6 +311,28 @@ func (t *Template) flash(c *Context, key string) string {
 	return c.Flash.Get(key)
 }
 
+// csrfToken is for "csrf_token" template function.
+func (t *Template) csrfToken(c *Context) string {
+	return c.csrfToken
+}
+
+// csrfField is for "csrf_field" template function.
+// It returns the hidden input field that holds the token of CSRFMiddleware.
+func (t *Template) csrfField(c *Context) template.HTML {
+	if c.csrfToken == "" {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(c.csrfFieldName), template.HTMLEscapeString(c.csrfToken)))
+}
+
+// cspNonce is for "csp_nonce" template function.
+// It returns the nonce of the Content-Security-Policy that is generated by
+// SecureHeadersMiddleware.
+func (t *Template) cspNonce(c *Context) string {
+	return c.cspNonce
+}
+
 // join is for "join" template function.
 func (t *Template) join(a interface{}, sep string) (string, error) {
 	v := reflect.ValueOf(a)
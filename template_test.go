@@ -84,6 +84,34 @@ func TestTemplate_FuncMap_url(t *testing.T) {
 	}()
 }
 
+func TestTemplate_FuncMap_url_with(t *testing.T) {
+	app := kocha.NewTestApp()
+	funcMap := template.FuncMap(app.Template.FuncMap)
+	for _, v := range []struct {
+		tmpl   string
+		expect string
+		err    error
+	}{
+		{`{{url_with "root"}}`, "/", nil},
+		{`{{url_with "user" "id" 713}}`, "/user/713", nil},
+		{`{{url_with "user" "id" "a b" "page" 2}}`, "/user/a%20b?page=2", nil},
+		{`{{url_with "user" "id"}}`, "", fmt.Errorf("number of arguments after the name must be even")},
+		{`{{url_with "user" 1 2}}`, "", fmt.Errorf("parameter name must be string, got int")},
+	} {
+		tmpl := template.Must(template.New("test").Funcs(funcMap).Parse(v.tmpl))
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, nil)
+		if !strings.HasSuffix(fmt.Sprint(err), fmt.Sprint(v.err)) {
+			t.Errorf(`%v; error has "%v"; want "%v"`, v.tmpl, err, v.err)
+		}
+		actual := buf.String()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%v => %#v; want %#v`, v.tmpl, actual, expect)
+		}
+	}
+}
+
 func TestTemplate_FuncMap_nl2br(t *testing.T) {
 	app := kocha.NewTestApp()
 	funcMap := template.FuncMap(app.Template.FuncMap)
@@ -284,6 +312,34 @@ func TestTemplateFuncMap_flash(t *testing.T) {
 	}
 }
 
+func TestTemplateFuncMap_csrf(t *testing.T) {
+	m := &kocha.CSRFMiddleware{}
+	app := newCSRFTestApp(t, m)
+	funcMap := template.FuncMap(app.Template.FuncMap)
+	tmpl := template.Must(template.New("test").Funcs(funcMap).Parse(`{{csrf_token .}}|{{csrf_field .}}`))
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &kocha.Context{
+		Request:  &kocha.Request{Request: r},
+		Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+		Session:  kocha.Session{"_kocha._csrf_token": "a<b"},
+		App:      app,
+	}
+	var buf bytes.Buffer
+	if err := m.Process(app, c, func() error {
+		return tmpl.Execute(&buf, c)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	actual := buf.String()
+	expect := `a&lt;b|<input type="hidden" name="_csrf_token" value="a&lt;b">`
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`{{csrf_token .}}|{{csrf_field .}} => %#v; want %#v`, actual, expect)
+	}
+}
+
 func TestTemplateFuncMap_join(t *testing.T) {
 	app := kocha.NewTestApp()
 	funcMap := template.FuncMap(app.Template.FuncMap)
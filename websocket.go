@@ -0,0 +1,369 @@
+package kocha
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The message types of the WebSocket.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// The status codes of the WebSocket close frame that are defined in RFC 6455.
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseUnsupportedData = 1003
+	WebSocketCloseNoStatus        = 1005
+	WebSocketCloseInvalidPayload  = 1007
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseMessageTooBig   = 1009
+	WebSocketCloseInternalError   = 1011
+)
+
+// DefaultWebSocketMaxMessageSize is the default maximum size of the message
+// that is read from the WebSocket.
+const DefaultWebSocketMaxMessageSize = 1024 * 1024 // 1MB
+
+// websocketGUID is the GUID to compute Sec-WebSocket-Accept header.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketer interface is an interface representing a handler for the
+// WebSocket connection.
+// If the controller implements WebSocketer, the upgrade request to the
+// WebSocket is passed to WebSocket instead of GET. The middlewares are
+// processed in front of it as usual, so Context.Session and Context.Params
+// can be used to authenticate the connection.
+// The connection is closed when WebSocket returns.
+//
+// The connection isn't limited by Config.Timeout and Route.Timeout. After the
+// upgrade, Context.Context() has no deadline and is done only when the server
+// shuts down, and then the connection is closed.
+type WebSocketer interface {
+	WebSocket(c *Context, conn *WebSocketConn) error
+}
+
+// WebSocketOriginChecker interface is an interface to check the Origin header
+// of the upgrade request to the WebSocket.
+// If the controller doesn't implement WebSocketOriginChecker, only the
+// requests from the same origin are accepted.
+type WebSocketOriginChecker interface {
+	CheckOrigin(c *Context, origin string) bool
+}
+
+// WebSocketCloseError represents the close frame that is received from the
+// peer.
+type WebSocketCloseError struct {
+	Code int
+	Text string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("kocha: websocket: closed by peer with %d %s", e.Code, e.Text)
+}
+
+// WebSocketConn represents a connection of the WebSocket.
+type WebSocketConn struct {
+	// Maximum size of the message to read.
+	// Default is DefaultWebSocketMaxMessageSize.
+	MaxMessageSize int64
+
+	// PongHandler is called when a pong is received.
+	PongHandler func(data []byte)
+
+	conn   net.Conn
+	br     *bufio.Reader
+	mu     sync.Mutex // for writing.
+	closed bool
+}
+
+// ReadMessage reads a text or binary message from the peer.
+// The ping is answered with the pong automatically. If the close frame is
+// received, it answers with the close frame and returns *WebSocketCloseError.
+func (ws *WebSocketConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if err := ws.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if ws.PongHandler != nil {
+				ws.PongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			closeErr := &WebSocketCloseError{Code: WebSocketCloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Text = string(payload[2:])
+			}
+			code := closeErr.Code
+			if code == WebSocketCloseNoStatus {
+				code = WebSocketCloseNormal
+			}
+			ws.Close(code, "")
+			return 0, nil, closeErr
+		case 0: // continuation frame.
+			if messageType == 0 {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "unexpected continuation frame")
+			}
+			p = append(p, payload...)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "unexpected new message in fragmented message")
+			}
+			messageType, p = opcode, payload
+		default:
+			return 0, nil, ws.fail(WebSocketCloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+		if int64(len(p)) > ws.maxMessageSize() {
+			return 0, nil, ws.fail(WebSocketCloseMessageTooBig, "message too big")
+		}
+		if !fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(p) {
+			return 0, nil, ws.fail(WebSocketCloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+		return messageType, p, nil
+	}
+}
+
+// WriteMessage writes a message to the peer.
+// messageType must be TextMessage, BinaryMessage, PingMessage or PongMessage.
+func (ws *WebSocketConn) WriteMessage(messageType int, p []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+	case PingMessage, PongMessage:
+		if len(p) > 125 {
+			return fmt.Errorf("kocha: websocket: payload of control frame must be 125 bytes or less")
+		}
+	default:
+		return fmt.Errorf("kocha: websocket: unsupported message type: %v", messageType)
+	}
+	return ws.writeFrame(messageType, p)
+}
+
+// Close sends the close frame with code and text to the peer, and closes the
+// connection. It's safe to call Close more than once.
+func (ws *WebSocketConn) Close(code int, text string) error {
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if len(text) > 123 {
+		text = text[:123]
+	}
+	ws.writeFrame(CloseMessage, append(payload, text...))
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return nil
+	}
+	ws.closed = true
+	return ws.conn.Close()
+}
+
+// SetReadDeadline sets the deadline of reading from the connection.
+func (ws *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of writing to the connection.
+func (ws *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the network address of the peer.
+func (ws *WebSocketConn) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+func (ws *WebSocketConn) maxMessageSize() int64 {
+	if ws.MaxMessageSize > 0 {
+		return ws.MaxMessageSize
+	}
+	return DefaultWebSocketMaxMessageSize
+}
+
+// fail closes the connection with code and returns the error of text.
+func (ws *WebSocketConn) fail(code int, text string) error {
+	ws.Close(code, text)
+	return fmt.Errorf("kocha: websocket: %s", text)
+}
+
+// readFrame reads a frame that is masked by the client.
+func (ws *WebSocketConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, int(header[0]&0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(WebSocketCloseProtocolError, "reserved bits must be 0")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, ws.fail(WebSocketCloseProtocolError, "frame from client must be masked")
+	}
+	length := int64(header[1] & 0x7f)
+	if opcode >= CloseMessage && (length > 125 || !fin) {
+		return false, 0, nil, ws.fail(WebSocketCloseProtocolError, "invalid control frame")
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if length < 0 || length > ws.maxMessageSize() {
+		return false, 0, nil, ws.fail(WebSocketCloseMessageTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes an unmasked frame.
+func (ws *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	buf := make([]byte, 0, 10+len(payload))
+	buf = append(buf, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 127)
+		buf = buf[:len(buf)+8]
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+	buf = append(buf, payload...)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return fmt.Errorf("kocha: websocket: connection has been closed")
+	}
+	_, err := ws.conn.Write(buf)
+	return err
+}
+
+// isWebSocketUpgrade returns whether the request is the upgrade request to
+// the WebSocket.
+func isWebSocketUpgrade(req *Request) bool {
+	return req.Method == "GET" &&
+		headerContainsToken(req.Header, "Connection", "upgrade") &&
+		headerContainsToken(req.Header, "Upgrade", "websocket")
+}
+
+// headerContainsToken returns whether the comma-separated header of name
+// contains token case-insensitively.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSameOrigin returns whether origin is same as the host of the request.
+func isSameOrigin(req *Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// serveWebSocket upgrades the connection to the WebSocket and passes it to
+// the WebSocketer.
+func (route *Route) serveWebSocket(c *Context) error {
+	if origin := c.Request.Header.Get("Origin"); origin != "" {
+		allowed := false
+		if checker, ok := route.Controller.(WebSocketOriginChecker); ok {
+			allowed = checker.CheckOrigin(c, origin)
+		} else {
+			allowed = isSameOrigin(c.Request, origin)
+		}
+		if !allowed {
+			return c.RenderError(http.StatusForbidden, nil, nil)
+		}
+	}
+	key := c.Request.Header.Get("Sec-WebSocket-Key")
+	if c.Request.Header.Get("Sec-WebSocket-Version") != "13" {
+		c.Response.Header().Set("Sec-WebSocket-Version", "13")
+		return c.RenderError(http.StatusBadRequest, nil, nil)
+	}
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		return c.RenderError(http.StatusBadRequest, nil, nil)
+	}
+	conn, rw, err := c.Response.hijack()
+	if err != nil {
+		return c.errorWithLine(err)
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		conn.Close()
+		return c.errorWithLine(err)
+	}
+	c.Response.StatusCode = http.StatusSwitchingProtocols
+	if c.ctx != nil {
+		// detaches the connection from the deadline of the request.
+		c.Request.Request = c.Request.WithContext(c.ctx)
+	}
+	ws := &WebSocketConn{conn: conn, br: rw.Reader}
+	// closes the connection on the timeout or the shutdown of the server.
+	stop := context.AfterFunc(c.Context(), func() {
+		ws.Close(WebSocketCloseGoingAway, "")
+	})
+	defer stop()
+	if err := route.webSocket(c, ws); err != nil {
+		ws.Close(WebSocketCloseInternalError, "")
+		return err
+	}
+	return ws.Close(WebSocketCloseNormal, "")
+}
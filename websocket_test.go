@@ -0,0 +1,252 @@
+package kocha_test
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/woremacx/kocha"
+)
+
+type testWebSocketCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testWebSocketCtrl) GET(c *kocha.Context) error {
+	return c.RenderText("GET")
+}
+
+func (ctrl *testWebSocketCtrl) WebSocket(c *kocha.Context, conn *kocha.WebSocketConn) error {
+	for {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			if _, ok := err.(*kocha.WebSocketCloseError); ok {
+				return nil
+			}
+			return err
+		}
+		if err := conn.WriteMessage(messageType, append([]byte(c.Params.Get("name")+":"), p...)); err != nil {
+			return err
+		}
+	}
+}
+
+func newWebSocketTestServer(t *testing.T) *httptest.Server {
+	config := kocha.NewTestApp().Config
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name:       "ws",
+		Path:       "/ws/:name",
+		Controller: &testWebSocketCtrl{},
+	})
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(app)
+}
+
+// dialWebSocket sends the upgrade request and returns the connection and
+// the response.
+func dialWebSocket(t *testing.T, server *httptest.Server, path string, header http.Header) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func writeClientFrame(t *testing.T, w io.Writer, fin bool, opcode int, payload []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	buf := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, 0x80|byte(n))
+	default:
+		buf = append(buf, 0x80|126, byte(n>>8), byte(n))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		t.Fatal(err)
+	}
+	buf = append(buf, mask[:]...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	if _, err := w.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readServerFrame(t *testing.T, r io.Reader) (opcode int, payload []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func TestWebSocketer(t *testing.T) {
+	server := newWebSocketTestServer(t)
+	defer server.Close()
+	conn, br, resp := dialWebSocket(t, server, "/ws/alice", nil)
+	defer conn.Close()
+	var actual interface{} = []interface{}{resp.StatusCode, resp.Header.Get("Upgrade"), resp.Header.Get("Sec-WebSocket-Accept")}
+	var expect interface{} = []interface{}{http.StatusSwitchingProtocols, "websocket", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Fatalf(`upgrade response => %#v; want %#v`, actual, expect)
+	}
+
+	for _, v := range []struct {
+		frames [][]interface{}
+		opcode int
+		expect string
+	}{
+		{[][]interface{}{{true, kocha.TextMessage, "hello"}}, kocha.TextMessage, "alice:hello"},
+		{[][]interface{}{{true, kocha.BinaryMessage, "\x00\x01"}}, kocha.BinaryMessage, "alice:\x00\x01"},
+		{[][]interface{}{{true, kocha.PingMessage, "ping"}}, kocha.PongMessage, "ping"},
+		{[][]interface{}{{false, kocha.TextMessage, "frag"}, {true, kocha.PingMessage, "p"}}, kocha.PongMessage, "p"},
+		{[][]interface{}{{true, 0, "mented"}}, kocha.TextMessage, "alice:fragmented"},
+		{[][]interface{}{{true, kocha.TextMessage, strings.Repeat("a", 200)}}, kocha.TextMessage, "alice:" + strings.Repeat("a", 200)},
+	} {
+		for _, f := range v.frames {
+			writeClientFrame(t, conn, f[0].(bool), f[1].(int), []byte(f[2].(string)))
+		}
+		opcode, payload := readServerFrame(t, br)
+		actual = []interface{}{opcode, string(payload)}
+		expect = []interface{}{v.opcode, v.expect}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`frames %#v => %#v; want %#v`, v.frames, actual, expect)
+		}
+	}
+
+	writeClientFrame(t, conn, true, kocha.CloseMessage, []byte{0x03, 0xe8})
+	opcode, payload := readServerFrame(t, br)
+	actual = []interface{}{opcode, payload}
+	expect = []interface{}{kocha.CloseMessage, []byte{0x03, 0xe8}}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`close frame => %#v; want %#v`, actual, expect)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf(`read after close => %#v; want %#v`, err, io.EOF)
+	}
+}
+
+type testWebSocketDeadlineCtrl struct {
+	*kocha.DefaultController
+}
+
+func (ctrl *testWebSocketDeadlineCtrl) WebSocket(c *kocha.Context, conn *kocha.WebSocketConn) error {
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return err
+	}
+	_, hasDeadline := c.Context().Deadline()
+	return conn.WriteMessage(kocha.TextMessage, []byte(fmt.Sprint(hasDeadline, c.Context().Err())))
+}
+
+func TestWebSocketer_withTimeout(t *testing.T) {
+	config := kocha.NewTestApp().Config
+	config.Timeout = time.Millisecond
+	config.RouteTable = append(config.RouteTable, &kocha.Route{
+		Name:       "ws",
+		Path:       "/ws",
+		Controller: &testWebSocketDeadlineCtrl{},
+	})
+	app, err := kocha.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(app)
+	defer server.Close()
+	conn, br, _ := dialWebSocket(t, server, "/ws", nil)
+	defer conn.Close()
+	writeClientFrame(t, conn, true, kocha.TextMessage, []byte("deadline"))
+	opcode, payload := readServerFrame(t, br)
+	var actual interface{} = []interface{}{opcode, string(payload)}
+	var expect interface{} = []interface{}{kocha.TextMessage, "false <nil>"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`WebSocket with Config.Timeout; Context().Deadline() and Context().Err() => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestWebSocketer_withProtocolError(t *testing.T) {
+	server := newWebSocketTestServer(t)
+	defer server.Close()
+	conn, br, _ := dialWebSocket(t, server, "/ws/alice", nil)
+	defer conn.Close()
+	writeClientFrame(t, conn, true, 0, []byte("unexpected"))
+	opcode, payload := readServerFrame(t, br)
+	var actual interface{} = []interface{}{opcode, int(binary.BigEndian.Uint16(payload))}
+	var expect interface{} = []interface{}{kocha.CloseMessage, kocha.WebSocketCloseProtocolError}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`unexpected continuation frame => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestWebSocketer_withInvalidRequest(t *testing.T) {
+	server := newWebSocketTestServer(t)
+	defer server.Close()
+	for _, v := range []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"Origin": {server.URL}}, http.StatusSwitchingProtocols},
+		{http.Header{"Origin": {"http://evil.example.com"}}, http.StatusForbidden},
+		{http.Header{"Sec-Websocket-Version": {"8"}}, http.StatusBadRequest},
+		{http.Header{"Sec-Websocket-Key": {"short"}}, http.StatusBadRequest},
+		{http.Header{"Upgrade": {"h2c"}}, http.StatusOK},
+	} {
+		func() {
+			conn, _, resp := dialWebSocket(t, server, "/ws/alice", v.header)
+			defer conn.Close()
+			actual := resp.StatusCode
+			expect := v.status
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf(`upgrade request with %#v; status => %#v; want %#v`, v.header, actual, expect)
+			}
+		}()
+	}
+}